@@ -0,0 +1,341 @@
+// Command netgamesim-headless runs the client, server and simulated network without a browser,
+// using a virtual clock and scripted input, and prints metrics. It makes it possible to compare
+// network settings from a terminal or a test.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/evanj/netgamesim/game"
+	"github.com/evanj/netgamesim/inputscript"
+	"github.com/evanj/netgamesim/intersect"
+	"github.com/evanj/netgamesim/netcode"
+	"github.com/evanj/netgamesim/netsim"
+)
+
+// rendered movement smaller than this many pixels is not movement
+const moveEpsilon = 0.01
+
+// config is the settings for one headless run.
+type config struct {
+	durationMS float64
+	frameMS    float64
+	seed       int64
+	link       netsim.LinkConfig
+	// the rules of the game
+	rules game.Config
+
+	predict          bool
+	reconcile        bool
+	interpolationMS  float64
+	lagCompensation  bool
+	deltaCompression bool
+
+	// lockstep uses deterministic lockstep instead of client/server, and rollback uses rollback
+	lockstep    bool
+	rollback    bool
+	inputDelay  int
+	maxRollback int
+}
+
+func defaultConfig() config {
+	return config{
+		60 * 1000, 1000.0 / 60, 1, netsim.LinkConfig{}, game.DefaultConfig(),
+		false, false, 0, false, false,
+		false, false, 2, 8,
+	}
+}
+
+// defaultScript moves the tank in a square, pausing in between, and fires every 20 ticks.
+const defaultScript = `repeat 160
+tick 0: right, fire
+tick 20: fire
+tick 32: down
+tick 40: fire
+tick 60: fire
+tick 64: none
+tick 80: fire
+tick 96: left
+tick 100: fire
+tick 120: fire
+tick 128: up
+tick 140: fire
+`
+
+// metrics are the results of a headless run.
+type metrics struct {
+	frames int
+	// time between sending a direction change and rendering the tank moving that way
+	delaysMS []float64
+	// direction changes that were never rendered, because the next change was sent first
+	notVisible int
+
+	lockstep bool
+	rollback bool
+
+	hits          netcode.HitStats
+	lockstepStats netcode.LockstepStats
+	// the client never mispredicts: only the server peer has rollbacks
+	clientRollback netcode.RollbackStats
+	serverRollback netcode.RollbackStats
+	corrections    netcode.CorrectionStats
+	desyncs        netcode.DesyncStats
+	snapshots      netcode.SnapshotStats
+	uplink         netsim.Stats
+	downlink       netsim.Stats
+}
+
+// delayPercentile returns the pth percentile input to visible delay, for 0 <= p <= 1.
+func (m *metrics) delayPercentile(p float64) float64 {
+	if len(m.delaysMS) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), m.delaysMS...)
+	sort.Float64s(sorted)
+	return sorted[int(p*float64(len(sorted)-1)+0.5)]
+}
+
+func (m *metrics) meanDelayMS() float64 {
+	if len(m.delaysMS) == 0 {
+		return math.NaN()
+	}
+	total := 0.0
+	for _, d := range m.delaysMS {
+		total += d
+	}
+	return total / float64(len(m.delaysMS))
+}
+
+func (m *metrics) hitRate() float64 {
+	if m.hits.Shots == 0 {
+		return math.NaN()
+	}
+	return float64(m.hits.Hits) / float64(m.hits.Shots)
+}
+
+func (m *metrics) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `frames: %d
+input to visible delay: n=%d mean=%.1fms p50=%.1fms p95=%.1fms max=%.1fms not visible=%d
+hits: %d/%d shots = %.1f%%
+`,
+		m.frames,
+		len(m.delaysMS), m.meanDelayMS(), m.delayPercentile(0.5), m.delayPercentile(0.95),
+		m.delayPercentile(1), m.notVisible,
+		m.hits.Hits, m.hits.Shots, 100*m.hitRate())
+	if err != nil {
+		return err
+	}
+
+	if m.lockstep {
+		_, err = fmt.Fprintf(w, "lockstep: %+v\n", m.lockstepStats)
+	} else if m.rollback {
+		_, err = fmt.Fprintf(w, "client rollback: %+v\nserver rollback: %+v\n",
+			m.clientRollback, m.serverRollback)
+	} else {
+		_, err = fmt.Fprintf(w, `prediction corrections: %+v
+prediction desyncs: %+v
+snapshots: %+v
+`,
+			m.corrections, m.desyncs, m.snapshots)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "uplink: %+v\ndownlink: %+v\n", m.uplink, m.downlink)
+	return err
+}
+
+// renderedDirection returns the direction the tank moved between two rendered frames.
+func renderedDirection(from intersect.Point, to intersect.Point) game.Direction {
+	d := to.Sub(from)
+	if math.Abs(d.X) < moveEpsilon && math.Abs(d.Y) < moveEpsilon {
+		return game.DirNone
+	}
+	if math.Abs(d.X) > math.Abs(d.Y) {
+		if d.X < 0 {
+			return game.DirLeft
+		}
+		return game.DirRight
+	}
+	if d.Y < 0 {
+		return game.DirUp
+	}
+	return game.DirDown
+}
+
+// model is a network model with a clock controlled by the caller: netcode.Loop,
+// netcode.LockstepLoop or netcode.RollbackLoop.
+type model interface {
+	AdvanceTo(nowMS float64)
+	SendInput(nowMS float64, i game.Input)
+	Render(nowMS float64) *game.Game
+}
+
+// run simulates the game with cfg, sending input from script every frame. It returns an error if
+// the settings in cfg are not valid.
+func run(cfg config, script *inputscript.Script) (*metrics, error) {
+	var loop model
+	var clientServer *netcode.Loop
+	var lockstep *netcode.LockstepLoop
+	var rollback *netcode.RollbackLoop
+	var err error
+	if cfg.lockstep {
+		lockstep, err = netcode.NewLockstepLoop(cfg.rules, cfg.seed, cfg.inputDelay)
+		if err != nil {
+			return nil, err
+		}
+		lockstep.Net.ClientToServer.Config = cfg.link
+		lockstep.Net.ServerToClient.Config = cfg.link
+		loop = lockstep
+	} else if cfg.rollback {
+		rollback, err = netcode.NewRollbackLoop(cfg.rules, cfg.seed, cfg.inputDelay, cfg.maxRollback)
+		if err != nil {
+			return nil, err
+		}
+		rollback.Net.ClientToServer.Config = cfg.link
+		rollback.Net.ServerToClient.Config = cfg.link
+		loop = rollback
+	} else {
+		clientServer, err = netcode.NewLoop(cfg.rules, cfg.seed)
+		if err != nil {
+			return nil, err
+		}
+		clientServer.Net.ClientToServer.Config = cfg.link
+		clientServer.Net.ServerToClient.Config = cfg.link
+		clientServer.Client.Predict = cfg.predict
+		clientServer.Client.Reconcile = cfg.reconcile
+		clientServer.Client.Interpolation.DelayMS = cfg.interpolationMS
+		clientServer.Client.LagCompensation = cfg.lagCompensation
+		clientServer.Server.LagCompensation = cfg.lagCompensation
+		clientServer.Server.DeltaCompression = cfg.deltaCompression
+		loop = clientServer
+	}
+
+	player := script.Player()
+	m := &metrics{}
+	m.lockstep = cfg.lockstep
+	m.rollback = cfg.rollback
+	lastDir := game.DirNone
+	waiting := false
+	var waitingDir game.Direction
+	var waitingSentMS float64
+	lastTank := loop.Render(0).TankCenter(0)
+	m.frames = int(math.Round(cfg.durationMS / cfg.frameMS))
+	for frame := 0; frame < m.frames; frame++ {
+		nowMS := float64(frame) * cfg.frameMS
+
+		loop.AdvanceTo(nowMS)
+		input := player.Input(int(nowMS / float64(cfg.rules.TimeStepMS)))
+		loop.SendInput(nowMS, input)
+		if input.TankDir != lastDir {
+			if waiting {
+				m.notVisible++
+			}
+			waiting = true
+			waitingDir = input.TankDir
+			waitingSentMS = nowMS
+			lastDir = input.TankDir
+		}
+
+		tank := loop.Render(nowMS).TankCenter(0)
+		if waiting && renderedDirection(lastTank, tank) == waitingDir {
+			m.delaysMS = append(m.delaysMS, nowMS-waitingSentMS)
+			waiting = false
+		}
+		lastTank = tank
+	}
+
+	if cfg.lockstep {
+		m.hits = lockstep.Server.HitStats()
+		m.lockstepStats = lockstep.Client.Stats()
+		m.uplink = lockstep.Net.ClientToServer.Stats()
+		m.downlink = lockstep.Net.ServerToClient.Stats()
+	} else if cfg.rollback {
+		m.hits = rollback.Server.HitStats()
+		m.clientRollback = rollback.Client.Stats()
+		m.serverRollback = rollback.Server.Stats()
+		m.uplink = rollback.Net.ClientToServer.Stats()
+		m.downlink = rollback.Net.ServerToClient.Stats()
+	} else {
+		m.hits = clientServer.Server.HitStats()
+		m.corrections = clientServer.Client.CorrectionStats()
+		m.desyncs = clientServer.Client.DesyncStats()
+		m.snapshots = clientServer.Server.SnapshotStats()
+		m.uplink = clientServer.Net.ClientToServer.Stats()
+		m.downlink = clientServer.Net.ServerToClient.Stats()
+	}
+	return m, nil
+}
+
+func main() {
+	cfg := defaultConfig()
+	duration := flag.Duration("duration", time.Duration(cfg.durationMS)*time.Millisecond,
+		"simulated time to run")
+	fps := flag.Float64("fps", 1000/cfg.frameMS, "client frames per second")
+	flag.Int64Var(&cfg.seed, "seed", cfg.seed, "seed for the simulated network")
+	flag.Float64Var(&cfg.link.LatencyMS, "latency", 0, "one way network latency (ms)")
+	flag.Float64Var(&cfg.link.JitterMS, "jitter", 0, "one way network jitter (ms)")
+	flag.Float64Var(&cfg.link.LossProbability, "loss", 0, "probability a message is lost (0-1)")
+	flag.IntVar(&cfg.rules.TimeStepMS, "time-step", cfg.rules.TimeStepMS,
+		"simulation time step (ms); 50 is 20 Hz like Quake")
+	flag.Float64Var(&cfg.rules.TankMovePerSecond, "tank-speed", cfg.rules.TankMovePerSecond,
+		"tank speed (pixels/second)")
+	flag.Float64Var(&cfg.rules.TargetMovePerSecond, "target-speed", cfg.rules.TargetMovePerSecond,
+		"target speed (pixels/second)")
+	flag.Float64Var(&cfg.rules.BulletMovePerSecond, "bullet-speed", cfg.rules.BulletMovePerSecond,
+		"bullet speed (pixels/second)")
+	flag.BoolVar(&cfg.predict, "predict", false, "client-side prediction")
+	flag.BoolVar(&cfg.reconcile, "reconcile", false, "server reconciliation")
+	flag.Float64Var(&cfg.interpolationMS, "interpolation", 0, "interpolation delay (ms)")
+	flag.BoolVar(&cfg.lagCompensation, "lag-compensation", false, "server lag compensation")
+	flag.BoolVar(&cfg.deltaCompression, "delta", false, "delta compressed states")
+	flag.BoolVar(&cfg.lockstep, "lockstep", false,
+		"deterministic lockstep instead of client/server; ignores the client/server settings")
+	flag.BoolVar(&cfg.rollback, "rollback", false,
+		"rollback instead of client/server; ignores the client/server settings")
+	flag.IntVar(&cfg.inputDelay, "input-delay", cfg.inputDelay,
+		"lockstep and rollback: time steps between reading input and simulating it")
+	flag.IntVar(&cfg.maxRollback, "max-rollback", cfg.maxRollback,
+		"rollback: most time steps to predict ahead of the remote input")
+	scriptPath := flag.String("script", "", "input script file (default: a built in script)")
+	verbose := flag.Bool("verbose", false, "log game events")
+	flag.Parse()
+
+	cfg.durationMS = float64(*duration / time.Millisecond)
+	cfg.frameMS = 1000 / *fps
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	scriptText := defaultScript
+	if *scriptPath != "" {
+		data, err := os.ReadFile(*scriptPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read script: %s\n", err.Error())
+			os.Exit(1)
+		}
+		scriptText = string(data)
+	}
+	script, err := inputscript.Parse(scriptText)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *scriptPath, err.Error())
+		os.Exit(1)
+	}
+
+	// the flags are validated by the game and netcode constructors
+	m, err := run(cfg, script)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if err := m.write(os.Stdout); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/evanj/netgamesim/inputscript"
+)
+
+func TestLatencySweep(t *testing.T) {
+	for _, latencyMS := range []float64{0, 50, 150} {
+		cfg := defaultConfig()
+		cfg.durationMS = 10 * 1000
+		cfg.link.LatencyMS = latencyMS
+
+		// the dumb client shows input after the round trip
+		dumb, err := run(cfg, inputscript.MustParse(defaultScript))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dumb.delaysMS) == 0 || dumb.notVisible != 0 {
+			t.Fatalf("latency=%f: delays=%v notVisible=%d; expected all changes to be visible",
+				latencyMS, dumb.delaysMS, dumb.notVisible)
+		}
+		if !(dumb.meanDelayMS() >= 2*latencyMS) {
+			t.Errorf("latency=%f: dumb mean delay=%f; expected at least the round trip",
+				latencyMS, dumb.meanDelayMS())
+		}
+
+		// the predicting client shows input in the next frame
+		cfg.predict = true
+		cfg.reconcile = true
+		predict, err := run(cfg, inputscript.MustParse(defaultScript))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if predict.delayPercentile(1) > 2*cfg.frameMS {
+			t.Errorf("latency=%f: predicting max delay=%f; expected at most two frames",
+				latencyMS, predict.delayPercentile(1))
+		}
+		if predict.hits.Shots == 0 || predict.hits.Hits == 0 {
+			t.Errorf("latency=%f: hits=%+v; expected the script to hit the target",
+				latencyMS, predict.hits)
+		}
+	}
+}
+
+func TestMetricsWrite(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.durationMS = 1000
+	m, err := run(cfg, inputscript.MustParse(defaultScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := m.write(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"frames: 60\n", "input to visible delay: n=", "hits: "} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output=%q; expected it to contain %q", out.String(), expected)
+		}
+	}
+}
+
+func TestRunInvalid(t *testing.T) {
+	for name, change := range map[string]func(cfg *config){
+		"rules":       func(cfg *config) { cfg.rules.TimeStepMS = 0 },
+		"inputDelay":  func(cfg *config) { cfg.lockstep = true; cfg.inputDelay = -1 },
+		"maxRollback": func(cfg *config) { cfg.rollback = true; cfg.maxRollback = 1000 },
+	} {
+		cfg := defaultConfig()
+		change(&cfg)
+		if _, err := run(cfg, inputscript.MustParse(defaultScript)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package game
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/evanj/netgamesim/intersect"
+	"github.com/evanj/netgamesim/sprites"
+)
+
+// DefaultTimeStepMS is the number of milliseconds of the default fixed timestep simulation.
+// We simulate at a fixed timestep 16 ms = 62.5 FPS which is really close to the 60 FPS
+// target for web games
+// see https://gafferongames.com/post/fix_your_timestep/
+const DefaultTimeStepMS = 16
+
+// DefaultWorldSize is the default width and height of the square game world.
+const DefaultWorldSize = 500
+
+// MaxRewindMS limits how far in the past lag compensation will test bullet hits.
+const MaxRewindMS = 250
+
+// Config contains the rules of the game. Games that exchange states or inputs must use the same
+// Config: it is not encoded. Start from DefaultConfig and change the fields to experiment.
+type Config struct {
+	// TimeStepMS is the number of milliseconds of a simulation time step.
+	TimeStepMS int
+
+	TankMovePerSecond   float64
+	TargetMovePerSecond float64
+	BulletMovePerSecond float64
+	// SmokeDisplaySeconds is how long the smoke of a hit is displayed.
+	SmokeDisplaySeconds float64
+
+	// WorldSize is the width and height of the square world. Tanks stay inside it, and bullets
+	// are removed when they leave it.
+	WorldSize float64
+	// TankStarts are the tanks' start positions: player i starts at
+	// TankStarts[i % len(TankStarts)].
+	TankStarts []intersect.Point
+	// The target starts at (TargetX, TargetMinY) and moves up and down between TargetMinY and
+	// TargetMaxY.
+	TargetX    float64
+	TargetMinY float64
+	TargetMaxY float64
+}
+
+// DefaultConfig returns the rules of the browser demo.
+func DefaultConfig() Config {
+	// players start in a column
+	tankStarts := make([]intersect.Point, 4)
+	for i := range tankStarts {
+		tankStarts[i] = intersect.Point{X: 75, Y: 75 + float64(i*100)}
+	}
+	return Config{
+		DefaultTimeStepMS,
+		300, 400, 900,
+		1,
+		DefaultWorldSize, tankStarts,
+		400, 50, 450,
+	}
+}
+
+// Validate returns an error if the simulation cannot run with c.
+func (c Config) Validate() error {
+	if !(1 <= c.TimeStepMS && c.TimeStepMS <= 1000) {
+		return fmt.Errorf("game: invalid TimeStepMS %d: must be between 1 and 1000", c.TimeStepMS)
+	}
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{
+		{"TankMovePerSecond", c.TankMovePerSecond},
+		{"TargetMovePerSecond", c.TargetMovePerSecond},
+		{"SmokeDisplaySeconds", c.SmokeDisplaySeconds},
+	} {
+		if !(0 <= field.value && field.value <= math.MaxFloat64) {
+			return fmt.Errorf("game: invalid %s %f: must be at least 0", field.name, field.value)
+		}
+	}
+	// bullets that do not move are never removed
+	if !(0 < c.BulletMovePerSecond && c.BulletMovePerSecond <= math.MaxFloat64) {
+		return fmt.Errorf("game: invalid BulletMovePerSecond %f: must be more than 0",
+			c.BulletMovePerSecond)
+	}
+
+	if !(sprites.TankSize <= c.WorldSize && c.WorldSize <= math.MaxFloat64) {
+		return fmt.Errorf("game: invalid WorldSize %f: must be at least the tank size %d",
+			c.WorldSize, sprites.TankSize)
+	}
+	if len(c.TankStarts) == 0 {
+		return fmt.Errorf("game: TankStarts must not be empty")
+	}
+	const half = sprites.TankSize / 2.0
+	for _, p := range c.TankStarts {
+		if !(c.inWorld(p.X, half) && c.inWorld(p.Y, half)) {
+			return fmt.Errorf("game: invalid tank start %s: must be inside the world", p)
+		}
+	}
+	if !(c.inWorld(c.TargetX, 0) && c.inWorld(c.TargetMinY, 0) && c.inWorld(c.TargetMaxY, 0) &&
+		c.TargetMinY <= c.TargetMaxY) {
+		return fmt.Errorf("game: invalid target X=%f MinY=%f MaxY=%f: must be inside the world",
+			c.TargetX, c.TargetMinY, c.TargetMaxY)
+	}
+	return nil
+}
+
+// inWorld returns true if v is at least margin away from the edges of the world.
+func (c Config) inWorld(v float64, margin float64) bool {
+	return margin <= v && v <= c.WorldSize-margin
+}
+
+// perTimeStep returns how far an object moving perSecond moves in one time step.
+func (c Config) perTimeStep(perSecond float64) float64 {
+	return perSecond * float64(c.TimeStepMS) / 1000.0
+}
+
+// smokeTimeSteps returns the number of time steps the smoke is displayed.
+func (c Config) smokeTimeSteps() int {
+	return int((c.SmokeDisplaySeconds*1000.0)/float64(c.TimeStepMS) + 0.5)
+}
+
+// maxRewindTicks returns how many ticks in the past lag compensation will test bullet hits.
+func (c Config) maxRewindTicks() int {
+	return MaxRewindMS / c.TimeStepMS
+}
+
+// targetHistoryLen returns the number of target positions to keep: bullets are tested against
+// where the target moved during a tick, so this is the tick before the oldest rewind too.
+func (c Config) targetHistoryLen() int {
+	return c.maxRewindTicks() + 2
+}
@@ -0,0 +1,229 @@
+package game
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/evanj/netgamesim/intersect"
+)
+
+// MaxDeltaTicks is the largest number of ticks between a baseline and the state encoded against
+// it, which covers a round trip time of MaxDeltaTicks * Config.TimeStepMS. After this long,
+// almost everything has changed anyway, so a full state should be sent.
+const MaxDeltaTicks = 127
+
+// ErrBaselineMismatch is returned by UnmarshalDelta if the delta was not encoded against the
+// baseline.
+var ErrBaselineMismatch = errors.New("game: delta does not match the baseline")
+
+// flags for the fields in a delta that are different from the baseline
+const (
+	deltaTarget = 1 << iota
+	deltaTargetDir
+	deltaMask = deltaTarget | deltaTargetDir
+)
+
+// flags for the fields of a tank that are different from the baseline
+const (
+	deltaTank = 1 << iota
+	deltaTankDir
+	deltaTankMask = deltaTank | deltaTankDir
+)
+
+// MarshalDelta encodes g as the changes since baseline, which must be a state of the same game
+// at most MaxDeltaTicks before g. The receiver must have the same baseline to decode it. The
+// encoding is: version, baseline ticks, ticks since the baseline, a byte of flags for the target
+// fields that changed and the changed fields, the number of tanks, then for each tank a byte of
+// flags and the changed fields, then the bullets and smoke. Tanks that are not in the baseline
+// are compared to the zero tank. Each bullet or smoke is encoded as a reference to the element
+// in the baseline that it moved from, or in full if it is new.
+func (g *Game) MarshalDelta(baseline *Game) ([]byte, error) {
+	timeSteps := g.simTicks - baseline.simTicks
+	if !(0 <= timeSteps && timeSteps <= MaxDeltaTicks) {
+		return nil, fmt.Errorf("game: baseline ticks %d must be at most %d ticks before ticks %d",
+			baseline.simTicks, MaxDeltaTicks, g.simTicks)
+	}
+
+	out := []byte{EncodingVersion}
+	out = binary.AppendUvarint(out, uint64(baseline.simTicks))
+	out = binary.AppendUvarint(out, uint64(timeSteps))
+
+	flags := byte(0)
+	if g.target != baseline.target {
+		flags |= deltaTarget
+	}
+	if g.targetDir != baseline.targetDir {
+		flags |= deltaTargetDir
+	}
+	out = append(out, flags)
+	if flags&deltaTarget != 0 {
+		out = appendPoint(out, g.target)
+	}
+	if flags&deltaTargetDir != 0 {
+		out = append(out, byte(g.targetDir))
+	}
+
+	out = binary.AppendUvarint(out, uint64(len(g.tanks)))
+	for i, t := range g.tanks {
+		base := baseline.tankOrZero(i)
+		tankFlags := byte(0)
+		if t.position != base.position {
+			tankFlags |= deltaTank
+		}
+		if t.dir != base.dir {
+			tankFlags |= deltaTankDir
+		}
+		out = append(out, tankFlags)
+		if tankFlags&deltaTank != 0 {
+			out = appendPoint(out, t.position)
+		}
+		if tankFlags&deltaTankDir != 0 {
+			out = append(out, byte(t.dir))
+		}
+	}
+
+	// bullets and smoke: 0 means a new element follows; i > 0 means baseline element i-1
+	advancedBullets := make([]bullet, len(baseline.bullets))
+	for i, b := range baseline.bullets {
+		advancedBullets[i] = baseline.advanceBullet(b, timeSteps)
+	}
+	out = binary.AppendUvarint(out, uint64(len(g.bullets)))
+	for _, b := range g.bullets {
+		ref := 0
+		for j, advanced := range advancedBullets {
+			if advanced == b {
+				ref = j + 1
+				break
+			}
+		}
+		out = binary.AppendUvarint(out, uint64(ref))
+		if ref == 0 {
+			out = appendPoint(out, b.position)
+			out = binary.AppendUvarint(out, uint64(b.rewindTicks))
+			out = binary.AppendUvarint(out, uint64(b.owner))
+		}
+	}
+	out = binary.AppendUvarint(out, uint64(len(g.smoke)))
+	for _, s := range g.smoke {
+		ref := 0
+		for j, baseSmoke := range baseline.smoke {
+			if advanceSmoke(baseSmoke, timeSteps) == s {
+				ref = j + 1
+				break
+			}
+		}
+		out = binary.AppendUvarint(out, uint64(ref))
+		if ref == 0 {
+			out = appendPoint(out, s.position)
+			out = binary.AppendUvarint(out, uint64(s.timeStepCount))
+		}
+	}
+	return out, nil
+}
+
+// UnmarshalDelta decodes a delta encoded by MarshalDelta against baseline. It returns
+// ErrBaselineMismatch if the delta was encoded against a different tick. Like
+// UnmarshalBinary, the target history used for lag compensation is not decoded. The decoded
+// game has the baseline's Config.
+func (g *Game) UnmarshalDelta(baseline *Game, data []byte) error {
+	dec := decoder{data, nil}
+	dec.version()
+	baselineTicks := dec.int()
+	if dec.err == nil && baselineTicks != baseline.simTicks {
+		return ErrBaselineMismatch
+	}
+	timeSteps := dec.int()
+	if dec.err == nil && timeSteps > MaxDeltaTicks {
+		dec.err = fmt.Errorf("game: delta time steps %d is more than %d", timeSteps, MaxDeltaTicks)
+	}
+
+	target := baseline.target
+	targetDir := baseline.targetDir
+	flags := dec.byte()
+	if dec.err == nil && flags&^deltaMask != 0 {
+		dec.err = fmt.Errorf("game: invalid delta flags 0x%02x", flags)
+	}
+	if flags&deltaTarget != 0 {
+		target = dec.point()
+	}
+	if flags&deltaTargetDir != 0 {
+		targetDir = dec.direction()
+	}
+
+	// each tank is at least one byte
+	tanks := make([]tank, dec.count(1))
+	for i := range tanks {
+		tanks[i] = baseline.tankOrZero(i)
+		tankFlags := dec.byte()
+		if dec.err == nil && tankFlags&^deltaTankMask != 0 {
+			dec.err = fmt.Errorf("game: invalid delta tank flags 0x%02x", tankFlags)
+		}
+		if tankFlags&deltaTank != 0 {
+			tanks[i].position = dec.point()
+		}
+		if tankFlags&deltaTankDir != 0 {
+			tanks[i].dir = dec.direction()
+		}
+	}
+
+	// each element is at least one byte
+	bullets := make([]bullet, dec.count(1))
+	for i := range bullets {
+		ref := dec.int()
+		if ref == 0 {
+			bullets[i].position = dec.point()
+			bullets[i].rewindTicks = dec.int()
+			bullets[i].owner = PlayerID(dec.int())
+		} else if ref <= len(baseline.bullets) {
+			bullets[i] = baseline.advanceBullet(baseline.bullets[ref-1], timeSteps)
+		} else if dec.err == nil {
+			dec.err = fmt.Errorf("game: invalid bullet reference %d", ref)
+		}
+	}
+	smokes := make([]smoke, dec.count(1))
+	for i := range smokes {
+		ref := dec.int()
+		if ref == 0 {
+			smokes[i].position = dec.point()
+			smokes[i].timeStepCount = dec.int()
+		} else if ref <= len(baseline.smoke) {
+			smokes[i] = advanceSmoke(baseline.smoke[ref-1], timeSteps)
+		} else if dec.err == nil {
+			dec.err = fmt.Errorf("game: invalid smoke reference %d", ref)
+		}
+	}
+	if err := dec.finish(); err != nil {
+		return err
+	}
+
+	decoded := newFromState(baseline.cfg, baselineTicks+timeSteps, tanks, target, targetDir, bullets, smokes)
+	if err := decoded.validate(); err != nil {
+		return err
+	}
+	*g = *decoded
+	return nil
+}
+
+// tankOrZero returns tank i, or the zero tank if the game does not have it.
+func (g *Game) tankOrZero(i int) tank {
+	if i < len(g.tanks) {
+		return g.tanks[i]
+	}
+	return tank{}
+}
+
+// advanceBullet returns b after timeSteps, if it does not hit anything. It must do the same
+// floating point operations as SimulateTimeStep so the result is exact.
+func (g *Game) advanceBullet(b bullet, timeSteps int) bullet {
+	bulletMove := g.cfg.perTimeStep(g.cfg.BulletMovePerSecond)
+	for i := 0; i < timeSteps; i++ {
+		b.position = b.position.Add(intersect.Point{X: bulletMove})
+	}
+	return b
+}
+
+func advanceSmoke(s smoke, timeSteps int) smoke {
+	s.timeStepCount += timeSteps
+	return s
+}
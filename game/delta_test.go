@@ -0,0 +1,136 @@
+package game
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	g := testGame()
+	baselines := []*Game{g.Clone()}
+	for i := 0; i < 60; i++ {
+		if i%7 == 0 {
+			g.ProcessInput(0, Input{TankDir: Direction(1 + i%4), Fire: true})
+		}
+		g.SimulateTimeStep()
+		baselines = append(baselines, g.Clone())
+	}
+
+	full, err := g.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, baseline := range baselines {
+		delta, err := g.MarshalDelta(baseline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &Game{}
+		err = decoded.UnmarshalDelta(baseline, delta)
+		if err != nil {
+			t.Fatalf("baselines[%d]: %s", i, err)
+		}
+		if !sameState(g, decoded) {
+			t.Errorf("baselines[%d]: decoded=%+v; expected %+v", i, decoded, g)
+		}
+	}
+
+	// the last few baselines have the same bullets: references are smaller than full bullets
+	delta, err := g.MarshalDelta(baselines[len(baselines)-2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta) >= len(full)/2 {
+		t.Errorf("len(delta)=%d; expected less than half of len(full)=%d", len(delta), len(full))
+	}
+
+	// a state without changes is a few bytes
+	delta, err = g.MarshalDelta(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta) > 16 {
+		t.Errorf("len(MarshalDelta(g))=%d; expected at most 16", len(delta))
+	}
+}
+
+func TestDeltaErrors(t *testing.T) {
+	g := testGame()
+	baseline := g.Clone()
+	g.SimulateTimeStep()
+
+	_, err := baseline.MarshalDelta(g)
+	if err == nil {
+		t.Error("MarshalDelta with a baseline in the future should fail")
+	}
+
+	old := g.Clone()
+	for old.Ticks()-baseline.Ticks() < MaxDeltaTicks {
+		old.SimulateTimeStep()
+	}
+	if _, err := old.MarshalDelta(baseline); err != nil {
+		t.Errorf("MarshalDelta with a baseline MaxDeltaTicks old failed: %v", err)
+	}
+	old.SimulateTimeStep()
+	if _, err := old.MarshalDelta(baseline); err == nil {
+		t.Error("MarshalDelta with a baseline more than MaxDeltaTicks old should fail")
+	}
+
+	delta, err := g.MarshalDelta(baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&Game{}).UnmarshalDelta(g, delta)
+	if err != ErrBaselineMismatch {
+		t.Errorf("UnmarshalDelta with the wrong baseline err=%v; expected %v", err, ErrBaselineMismatch)
+	}
+	for i := 0; i < len(delta); i++ {
+		if err := (&Game{}).UnmarshalDelta(baseline, delta[:i]); err == nil {
+			t.Errorf("UnmarshalDelta(delta[:%d]) should fail", i)
+		}
+	}
+}
+
+func FuzzGameUnmarshalDelta(f *testing.F) {
+	baseline := testGame()
+	g := baseline.Clone()
+	for i := 0; i < 10; i++ {
+		g.ProcessInput(0, Input{TankDir: DirRight, Fire: true})
+		g.SimulateTimeStep()
+	}
+	delta, err := g.MarshalDelta(baseline)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(delta)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoded := &Game{}
+		if err := decoded.UnmarshalDelta(baseline, data); err != nil {
+			return
+		}
+
+		// valid data must encode to a delta that decodes to the same state
+		encoded, err := decoded.MarshalDelta(baseline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip := &Game{}
+		if err := roundTrip.UnmarshalDelta(baseline, encoded); err != nil {
+			t.Fatal(err)
+		}
+		// compare the full encodings since positions can be NaN
+		expected, err := decoded.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTripBytes, err := roundTrip.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(roundTripBytes, expected) {
+			t.Errorf("round trip=%+v; expected %+v", roundTrip, decoded)
+		}
+		decoded.SimulateTimeStep()
+	})
+}
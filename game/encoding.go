@@ -0,0 +1,472 @@
+package game
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/evanj/netgamesim/intersect"
+)
+
+// EncodingVersion is the version of the binary and JSON encodings. It is the first byte of
+// the binary encoding of Game and Input. Decoding rejects other versions.
+const EncodingVersion = 3
+
+var errTruncated = errors.New("game: truncated data")
+var errTrailingData = errors.New("game: unexpected data after the end")
+
+var directionNames = []string{"none", "left", "up", "right", "down"}
+
+func (d Direction) valid() bool {
+	return DirNone <= d && d <= DirDown
+}
+
+func (d Direction) String() string {
+	if !d.valid() {
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+	return directionNames[d]
+}
+
+// MarshalText encodes d as its name, e.g. "left".
+func (d Direction) MarshalText() ([]byte, error) {
+	if !d.valid() {
+		return nil, fmt.Errorf("game: invalid direction %d", int(d))
+	}
+	return []byte(directionNames[d]), nil
+}
+
+// UnmarshalText decodes the name of a direction.
+func (d *Direction) UnmarshalText(text []byte) error {
+	for i, name := range directionNames {
+		if string(text) == name {
+			*d = Direction(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("game: invalid direction %#v", string(text))
+}
+
+// MarshalBinary encodes d as one byte.
+func (d Direction) MarshalBinary() ([]byte, error) {
+	if !d.valid() {
+		return nil, fmt.Errorf("game: invalid direction %d", int(d))
+	}
+	return []byte{byte(d)}, nil
+}
+
+// UnmarshalBinary decodes a direction encoded by MarshalBinary.
+func (d *Direction) UnmarshalBinary(data []byte) error {
+	dec := decoder{data, nil}
+	*d = dec.direction()
+	return dec.finish()
+}
+
+var eventNames = []string{"none", "fire"}
+
+func (e Event) valid() bool {
+	return EventNone <= e && e <= EventFire
+}
+
+func (e Event) String() string {
+	if !e.valid() {
+		return fmt.Sprintf("Event(%d)", int(e))
+	}
+	return eventNames[e]
+}
+
+// MarshalText encodes e as its name, e.g. "fire".
+func (e Event) MarshalText() ([]byte, error) {
+	if !e.valid() {
+		return nil, fmt.Errorf("game: invalid event %d", int(e))
+	}
+	return []byte(eventNames[e]), nil
+}
+
+// UnmarshalText decodes the name of an event.
+func (e *Event) UnmarshalText(text []byte) error {
+	for i, name := range eventNames {
+		if string(text) == name {
+			*e = Event(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("game: invalid event %#v", string(text))
+}
+
+// MarshalBinary encodes e as one byte.
+func (e Event) MarshalBinary() ([]byte, error) {
+	if !e.valid() {
+		return nil, fmt.Errorf("game: invalid event %d", int(e))
+	}
+	return []byte{byte(e)}, nil
+}
+
+// UnmarshalBinary decodes an event encoded by MarshalBinary.
+func (e *Event) UnmarshalBinary(data []byte) error {
+	dec := decoder{data, nil}
+	*e = Event(dec.byte())
+	if dec.err == nil && !e.valid() {
+		dec.err = fmt.Errorf("game: invalid event %d", int(*e))
+	}
+	return dec.finish()
+}
+
+const inputFlagFire = 1
+
+// MarshalBinary encodes the input as: version, direction, flags, view tick (uvarint).
+func (i Input) MarshalBinary() ([]byte, error) {
+	if !i.TankDir.valid() {
+		return nil, fmt.Errorf("game: invalid direction %d", int(i.TankDir))
+	}
+	if i.ViewTick < 0 {
+		return nil, fmt.Errorf("game: invalid view tick %d", i.ViewTick)
+	}
+	flags := byte(0)
+	if i.Fire {
+		flags |= inputFlagFire
+	}
+	out := []byte{EncodingVersion, byte(i.TankDir), flags}
+	return binary.AppendUvarint(out, uint64(i.ViewTick)), nil
+}
+
+// UnmarshalBinary decodes an input encoded by MarshalBinary.
+func (i *Input) UnmarshalBinary(data []byte) error {
+	dec := decoder{data, nil}
+	dec.version()
+	i.TankDir = dec.direction()
+	flags := dec.byte()
+	if dec.err == nil && flags&^inputFlagFire != 0 {
+		dec.err = fmt.Errorf("game: invalid input flags 0x%02x", flags)
+	}
+	i.Fire = flags&inputFlagFire != 0
+	i.ViewTick = dec.int()
+	return dec.finish()
+}
+
+// MarshalBinary encodes the game as: version, ticks, the tanks' positions and directions, target
+// position and direction, then the bullets and smoke. The tanks, bullets and smoke are each
+// preceded by their count. Positions are little endian float64s, so decoding is exact and the
+// simulation stays deterministic. Integers are uvarints. The target history used for lag
+// compensation is not encoded: after decoding, the game only knows the target's current
+// position.
+func (g *Game) MarshalBinary() ([]byte, error) {
+	// target position and direction + a few bytes for the counts
+	out := make([]byte, 0, 32+17*len(g.tanks)+19*len(g.bullets)+18*len(g.smoke))
+	out = append(out, EncodingVersion)
+	out = binary.AppendUvarint(out, uint64(g.simTicks))
+	out = binary.AppendUvarint(out, uint64(len(g.tanks)))
+	for _, t := range g.tanks {
+		out = appendPoint(out, t.position)
+		out = append(out, byte(t.dir))
+	}
+	out = appendPoint(out, g.target)
+	out = append(out, byte(g.targetDir))
+
+	out = binary.AppendUvarint(out, uint64(len(g.bullets)))
+	for _, b := range g.bullets {
+		out = appendPoint(out, b.position)
+		out = binary.AppendUvarint(out, uint64(b.rewindTicks))
+		out = binary.AppendUvarint(out, uint64(b.owner))
+	}
+	out = binary.AppendUvarint(out, uint64(len(g.smoke)))
+	for _, s := range g.smoke {
+		out = appendPoint(out, s.position)
+		out = binary.AppendUvarint(out, uint64(s.timeStepCount))
+	}
+	return out, nil
+}
+
+// Checksum returns a hash of the simulation state: the tanks, target, bullets, smoke and ticks.
+// Two games with the same state have the same checksum on any platform, so simulations can
+// compare checksums to find where they diverge. Like MarshalBinary, it does not include the
+// target history.
+func (g *Game) Checksum() uint64 {
+	data, err := g.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
+// UnmarshalBinary decodes a game encoded by MarshalBinary. The Config is not encoded: the
+// decoded game keeps the Config of g, or DefaultConfig if g is the zero Game.
+func (g *Game) UnmarshalBinary(data []byte) error {
+	dec := decoder{data, nil}
+	dec.version()
+	simTicks := dec.int()
+	// each tank, bullet and smoke is at least 17 bytes: limit the allocation for corrupt counts
+	const minElementSize = 2*8 + 1
+	tanks := make([]tank, dec.count(minElementSize))
+	for i := range tanks {
+		tanks[i].position = dec.point()
+		tanks[i].dir = dec.direction()
+	}
+	target := dec.point()
+	targetDir := dec.direction()
+
+	bullets := make([]bullet, dec.count(minElementSize))
+	for i := range bullets {
+		bullets[i].position = dec.point()
+		bullets[i].rewindTicks = dec.int()
+		bullets[i].owner = PlayerID(dec.int())
+	}
+	smokes := make([]smoke, dec.count(minElementSize))
+	for i := range smokes {
+		smokes[i].position = dec.point()
+		smokes[i].timeStepCount = dec.int()
+	}
+	if err := dec.finish(); err != nil {
+		return err
+	}
+
+	decoded := newFromState(g.config(), simTicks, tanks, target, targetDir, bullets, smokes)
+	if err := decoded.validate(); err != nil {
+		return err
+	}
+	*g = *decoded
+	return nil
+}
+
+// config returns the rules of g, or the default rules if g is the zero Game.
+func (g *Game) config() Config {
+	if g.cfg.TimeStepMS == 0 {
+		return DefaultConfig()
+	}
+	return g.cfg
+}
+
+// newFromState returns a game with the target history set to the current target.
+func newFromState(cfg Config, simTicks int, tanks []tank, target intersect.Point, targetDir Direction,
+	bullets []bullet, smokes []smoke) *Game {
+
+	if len(bullets) == 0 {
+		bullets = nil
+	}
+	if len(smokes) == 0 {
+		smokes = nil
+	}
+	g := &Game{cfg, tanks, target, targetDir, make([]intersect.Point, cfg.targetHistoryLen()),
+		bullets, smokes, simTicks}
+	for i := range g.targetHistory {
+		g.targetHistory[i] = target
+	}
+	return g
+}
+
+// validate returns an error if the simulation cannot run g.
+func (g *Game) validate() error {
+	for _, t := range g.tanks {
+		if !t.dir.valid() {
+			return fmt.Errorf("game: invalid tank direction %d", int(t.dir))
+		}
+	}
+	if g.targetDir != DirUp && g.targetDir != DirDown {
+		return fmt.Errorf("game: invalid target direction %s", g.targetDir)
+	}
+	if g.simTicks < 0 {
+		return fmt.Errorf("game: invalid ticks %d", g.simTicks)
+	}
+	for _, b := range g.bullets {
+		if !(0 <= b.rewindTicks && b.rewindTicks <= g.cfg.maxRewindTicks()) {
+			return fmt.Errorf("game: invalid bullet rewind ticks %d", b.rewindTicks)
+		}
+		if !(0 <= b.owner && int(b.owner) < len(g.tanks)) {
+			return fmt.Errorf("game: invalid bullet owner %d", b.owner)
+		}
+	}
+	for _, s := range g.smoke {
+		if s.timeStepCount < 0 {
+			return fmt.Errorf("game: invalid smoke time steps %d", s.timeStepCount)
+		}
+	}
+	return nil
+}
+
+// gameJSON is the JSON encoding of Game, for debugging.
+type gameJSON struct {
+	Version   int
+	Ticks     int
+	Tanks     []tankJSON
+	Target    intersect.Point
+	TargetDir Direction
+	Bullets   []bulletJSON
+	Smoke     []smokeJSON
+}
+
+type tankJSON struct {
+	Position intersect.Point
+	Dir      Direction
+}
+
+type bulletJSON struct {
+	Position    intersect.Point
+	RewindTicks int
+	Owner       PlayerID
+}
+
+type smokeJSON struct {
+	Position  intersect.Point
+	TimeSteps int
+}
+
+// MarshalJSON encodes the game as a JSON object, for debugging.
+func (g *Game) MarshalJSON() ([]byte, error) {
+	out := gameJSON{EncodingVersion, g.simTicks, make([]tankJSON, len(g.tanks)), g.target,
+		g.targetDir, make([]bulletJSON, len(g.bullets)), make([]smokeJSON, len(g.smoke))}
+	for i, t := range g.tanks {
+		out.Tanks[i] = tankJSON{t.position, t.dir}
+	}
+	for i, b := range g.bullets {
+		out.Bullets[i] = bulletJSON{b.position, b.rewindTicks, b.owner}
+	}
+	for i, s := range g.smoke {
+		out.Smoke[i] = smokeJSON{s.position, s.timeStepCount}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a game encoded by MarshalJSON. Like UnmarshalBinary, it keeps the
+// Config of g.
+func (g *Game) UnmarshalJSON(data []byte) error {
+	var in gameJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	if in.Version != EncodingVersion {
+		return fmt.Errorf("game: unsupported encoding version %d", in.Version)
+	}
+	tanks := make([]tank, len(in.Tanks))
+	for i, t := range in.Tanks {
+		tanks[i] = tank{t.Position, t.Dir}
+	}
+	bullets := make([]bullet, len(in.Bullets))
+	for i, b := range in.Bullets {
+		bullets[i] = bullet{b.Position, b.RewindTicks, b.Owner}
+	}
+	smokes := make([]smoke, len(in.Smoke))
+	for i, s := range in.Smoke {
+		smokes[i] = smoke{s.Position, s.TimeSteps}
+	}
+
+	decoded := newFromState(g.config(), in.Ticks, tanks, in.Target, in.TargetDir, bullets, smokes)
+	if err := decoded.validate(); err != nil {
+		return err
+	}
+	*g = *decoded
+	return nil
+}
+
+func appendPoint(out []byte, p intersect.Point) []byte {
+	out = binary.LittleEndian.AppendUint64(out, math.Float64bits(p.X))
+	return binary.LittleEndian.AppendUint64(out, math.Float64bits(p.Y))
+}
+
+// decoder reads values from data. After the first error, it returns zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.err = errTruncated
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) version() {
+	v := d.byte()
+	if d.err == nil && v != EncodingVersion {
+		d.err = fmt.Errorf("game: unsupported encoding version %d", v)
+	}
+}
+
+func (d *decoder) direction() Direction {
+	dir := Direction(d.byte())
+	if d.err == nil && !dir.valid() {
+		d.err = fmt.Errorf("game: invalid direction %d", int(dir))
+	}
+	return dir
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		if n < 0 {
+			d.err = errors.New("game: uvarint overflows 64 bits")
+		}
+		return 0
+	}
+	if n > 1 && d.data[n-1] == 0 {
+		// only accept the shortest encoding, so each game has exactly one encoding
+		d.err = errors.New("game: uvarint is not minimally encoded")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// int reads a uvarint that must fit in an int.
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		if d.err == nil {
+			d.err = fmt.Errorf("game: integer %d is too large", v)
+		}
+		return 0
+	}
+	return int(v)
+}
+
+// count reads the number of following elements, each at least minSize bytes.
+func (d *decoder) count(minSize int) int {
+	n := d.int()
+	if d.err == nil && n > len(d.data)/minSize {
+		d.err = errTruncated
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) < 8 {
+		d.err = errTruncated
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.data))
+	d.data = d.data[8:]
+	return v
+}
+
+func (d *decoder) point() intersect.Point {
+	x := d.float()
+	y := d.float()
+	return intersect.Point{X: x, Y: y}
+}
+
+// finish returns the first error, or an error if there is unread data.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) != 0 {
+		d.err = errTrailingData
+	}
+	return d.err
+}
@@ -0,0 +1,315 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// testGame returns a game with two players, bullets and smoke.
+func testGame() *Game {
+	g := MustNew(DefaultConfig())
+	other := g.AddPlayer()
+	g.ProcessInput(0, Input{TankDir: DirDown})
+	g.ProcessInput(other, Input{TankDir: DirRight})
+	for i := 0; i < 100; i++ {
+		if i%5 == 0 {
+			g.ProcessInput(0, Input{TankDir: DirDown, Fire: true, ViewTick: i})
+		}
+		if i%7 == 0 {
+			g.ProcessInput(other, Input{TankDir: DirRight, Fire: true})
+		}
+		g.SimulateTimeStep()
+	}
+	if len(g.bullets) == 0 || len(g.smoke) == 0 {
+		panic("testGame should have bullets and smoke")
+	}
+	return g
+}
+
+// sameState returns true if a and b are the same, ignoring the target history and the
+// difference between nil and empty slices.
+func sameState(a *Game, b *Game) bool {
+	a = a.Clone()
+	b = b.Clone()
+	for _, g := range []*Game{a, b} {
+		g.targetHistory = nil
+		if len(g.bullets) == 0 {
+			g.bullets = nil
+		}
+		if len(g.smoke) == 0 {
+			g.smoke = nil
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func TestGameBinaryRoundTrip(t *testing.T) {
+	for _, g := range []*Game{MustNew(DefaultConfig()), testGame()} {
+		data, err := g.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &Game{}
+		err = decoded.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameState(g, decoded) {
+			t.Errorf("decoded=%+v; expected %+v", decoded, g)
+		}
+
+		// the decoded game must simulate the same way
+		for i := 0; i < 100; i++ {
+			g.SimulateTimeStep()
+			decoded.SimulateTimeStep()
+		}
+		if !sameState(g, decoded) {
+			t.Errorf("decoded game simulated differently: %+v; expected %+v", decoded, g)
+		}
+	}
+}
+
+func TestGameJSONRoundTrip(t *testing.T) {
+	g := testGame()
+	data, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"Dir":"down"`)) {
+		t.Errorf("JSON=%s; expected tank direction as a name", string(data))
+	}
+	decoded := &Game{}
+	err = json.Unmarshal(data, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameState(g, decoded) {
+		t.Errorf("decoded=%+v; expected %+v", decoded, g)
+	}
+
+	for _, bad := range []string{
+		`{"Version":2}`,
+		`{"Version":3,"TargetDir":"down","Tanks":[{"Dir":"sideways"}]}`,
+		// the target must move up or down
+		`{"Version":3,"TargetDir":"none"}`,
+		// the bullet's owner must be a player
+		`{"Version":3,"TargetDir":"down","Tanks":[{}],"Bullets":[{"Owner":1}]}`,
+	} {
+		err = json.Unmarshal([]byte(bad), decoded)
+		if err == nil {
+			t.Errorf("Unmarshal(%s) should fail", bad)
+		}
+	}
+}
+
+func TestInputRoundTrip(t *testing.T) {
+	for _, input := range []Input{
+		{},
+		{TankDir: DirLeft, Fire: true, ViewTick: 12345},
+		{TankDir: DirDown},
+	} {
+		data, err := input.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Input
+		err = decoded.UnmarshalBinary(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != input {
+			t.Errorf("decoded=%+v; expected %+v", decoded, input)
+		}
+
+		data, err = json.Marshal(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded = Input{}
+		err = json.Unmarshal(data, &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != input {
+			t.Errorf("JSON decoded=%+v; expected %+v", decoded, input)
+		}
+	}
+
+	_, err := Input{TankDir: Direction(42)}.MarshalBinary()
+	if err == nil {
+		t.Error("MarshalBinary with an invalid direction should fail")
+	}
+}
+
+func TestDirectionEventEncoding(t *testing.T) {
+	for d := DirNone; d <= DirDown; d++ {
+		text, err := d.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Direction
+		if err := decoded.UnmarshalText(text); err != nil || decoded != d {
+			t.Errorf("UnmarshalText(%s)=%s,%v; expected %s", text, decoded, err, d)
+		}
+		data, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.UnmarshalBinary(data); err != nil || decoded != d {
+			t.Errorf("UnmarshalBinary(%v)=%s,%v; expected %s", data, decoded, err, d)
+		}
+	}
+
+	for e := EventNone; e <= EventFire; e++ {
+		text, err := e.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Event
+		if err := decoded.UnmarshalText(text); err != nil || decoded != e {
+			t.Errorf("UnmarshalText(%s)=%s,%v; expected %s", text, decoded, err, e)
+		}
+		data, err := e.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.UnmarshalBinary(data); err != nil || decoded != e {
+			t.Errorf("UnmarshalBinary(%v)=%s,%v; expected %s", data, decoded, err, e)
+		}
+	}
+
+	var d Direction
+	if err := d.UnmarshalBinary([]byte{5}); err == nil {
+		t.Error("UnmarshalBinary of an invalid direction should fail")
+	}
+	var e Event
+	if err := e.UnmarshalBinary([]byte{1, 1}); err == nil {
+		t.Error("UnmarshalBinary with trailing data should fail")
+	}
+}
+
+func TestGameUnmarshalBinaryErrors(t *testing.T) {
+	data, err := testGame().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every truncation must fail
+	for i := 0; i < len(data); i++ {
+		g := &Game{}
+		if err := g.UnmarshalBinary(data[:i]); err == nil {
+			t.Errorf("UnmarshalBinary(data[:%d]) should fail", i)
+		}
+	}
+
+	wrongVersion := bytes.Clone(data)
+	wrongVersion[0] = EncodingVersion + 1
+	if err := (&Game{}).UnmarshalBinary(wrongVersion); err == nil {
+		t.Error("UnmarshalBinary with the wrong version should fail")
+	}
+	// ticks=0 is encoded as one byte; the two byte encoding is not accepted
+	nonMinimal := append([]byte{data[0], 0x80, 0x00}, data[2:]...)
+	if err := (&Game{}).UnmarshalBinary(nonMinimal); err == nil {
+		t.Error("UnmarshalBinary with a non-minimal uvarint should fail")
+	}
+	if err := (&Game{}).UnmarshalBinary(append(data, 0)); err != errTrailingData {
+		t.Errorf("UnmarshalBinary with trailing data err=%v; expected %v", err, errTrailingData)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	g := testGame()
+	sum := g.Checksum()
+	if g.Clone().Checksum() != sum {
+		t.Error("Clone must have the same checksum")
+	}
+	data, err := g.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Game{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Checksum() != sum {
+		t.Error("decoded game must have the same checksum")
+	}
+
+	// changing any part of the state changes the checksum
+	for name, change := range map[string]func(g *Game){
+		"tank":      func(g *Game) { g.tanks[1].position.X += 0.001 },
+		"tankDir":   func(g *Game) { g.tanks[1].dir = DirLeft },
+		"players":   func(g *Game) { g.AddPlayer() },
+		"owner":     func(g *Game) { g.bullets[0].owner = 1 - g.bullets[0].owner },
+		"target":    func(g *Game) { g.target.Y += 0.001 },
+		"targetDir": func(g *Game) { g.targetDir = DirUp + DirDown - g.targetDir },
+		"bullet":    func(g *Game) { g.bullets[0].position.X += 0.001 },
+		"rewind":    func(g *Game) { g.bullets[0].rewindTicks++ },
+		"smoke":     func(g *Game) { g.smoke[0].timeStepCount++ },
+		"simTicks":  func(g *Game) { g.simTicks++ },
+		"order":     func(g *Game) { g.bullets = append(g.bullets[1:], g.bullets[0]) },
+	} {
+		changed := g.Clone()
+		change(changed)
+		if changed.Checksum() == sum {
+			t.Errorf("changing %s did not change the checksum", name)
+		}
+	}
+}
+
+func FuzzGameUnmarshalBinary(f *testing.F) {
+	for _, g := range []*Game{MustNew(DefaultConfig()), testGame()} {
+		data, err := g.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		g := &Game{}
+		if err := g.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// valid data must round trip and be possible to simulate
+		encoded, err := g.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Errorf("MarshalBinary()=%v; expected the original %v", encoded, data)
+		}
+		for player := PlayerID(0); int(player) < g.Players(); player++ {
+			g.ProcessInput(player, Input{TankDir: DirRight, Fire: true, ViewTick: 1})
+		}
+		g.SimulateTimeStep()
+	})
+}
+
+func FuzzInputUnmarshalBinary(f *testing.F) {
+	for _, input := range []Input{{}, {TankDir: DirUp, Fire: true, ViewTick: 300}} {
+		data, err := input.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var input Input
+		if err := input.UnmarshalBinary(data); err != nil {
+			return
+		}
+		encoded, err := input.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Input
+		if err := decoded.UnmarshalBinary(encoded); err != nil || decoded != input {
+			t.Errorf("round trip of %+v = %+v,%v", input, decoded, err)
+		}
+	})
+}
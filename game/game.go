@@ -2,6 +2,7 @@
 package game
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/evanj/netgamesim/intersect"
@@ -9,33 +10,11 @@ import (
 	"golang.org/x/exp/slices"
 )
 
-// the game world is 500x500
-const maxEdgeDimension = 500
-
-// TimeStepMS is the number of milliseconds of our fixed timestep simulation.
-// We simulate at a fixed timestep 16 ms = 62.5 FPS which is really close to the 60 FPS
-// target for web games
-// see https://gafferongames.com/post/fix_your_timestep/
-const TimeStepMS = 16
-
-const tankMovePerSecond = 300
-const tankMovePerTimeStep = (tankMovePerSecond * TimeStepMS) / 1000.0
-
-const targetMovePerSecond = 400
-const targetMovePerTimeStep = (targetMovePerSecond * TimeStepMS) / 1000.0
-
-const bulletMovePerSecond = 900
-const bulletMovePerTimeStep = (bulletMovePerSecond * TimeStepMS) / 1000.0
-
-const smokeDisplaySeconds = 1
-const smokeDisplayTimeSteps = int((smokeDisplaySeconds*1000.0)/TimeStepMS + 0.5)
-
-const tankInitialX = 75
-const tankInitialY = 75
-
-const targetX = 400
-const targetMaxY = 450
-const targetMinY = 50
+// the collision shapes match the sprites: the target is a circle, and a bullet is a line
+// BulletSize long and BulletSize/3 thick, which is close to a capsule
+const targetRadius = sprites.TargetSize / 2.0
+const bulletRadius = sprites.BulletSize / 6.0
+const bulletHalfLength = sprites.BulletSize/2.0 - bulletRadius
 
 // Direction encodes the direction of movement.
 type Direction int
@@ -55,6 +34,23 @@ const (
 	EventFire
 )
 
+// PlayerID identifies a player, who controls one tank. Players are numbered from 0 in the order
+// they are added to the game.
+type PlayerID int
+
+type tank struct {
+	position intersect.Point
+	dir      Direction
+}
+
+type bullet struct {
+	position intersect.Point
+	// number of ticks in the past that hits are tested against the target
+	rewindTicks int
+	// the player who fired the bullet
+	owner PlayerID
+}
+
 type smoke struct {
 	position      intersect.Point
 	timeStepCount int
@@ -63,26 +59,45 @@ type smoke struct {
 // Game contains the state of the world and can advance the simulation.
 // It does not know how to move
 type Game struct {
-	tank    intersect.Point
-	tankDir Direction
+	cfg Config
+
+	// indexed by PlayerID
+	tanks []tank
 
 	target    intersect.Point
 	targetDir Direction
+	// the target's position for the last maxRewindTicks()+1 ticks, indexed by tick % len
+	targetHistory []intersect.Point
 
-	bullets []intersect.Point
+	bullets []bullet
 	smoke   []smoke
 
 	simTicks int
 }
 
-// TankCenter returns the current tank center.
-func (g *Game) TankCenter() intersect.Point { return g.tank }
+// Config returns the rules of the game.
+func (g *Game) Config() Config { return g.cfg }
+
+// Players returns the number of players. Their IDs are 0 to Players()-1.
+func (g *Game) Players() int { return len(g.tanks) }
+
+// TankCenter returns the current center of player's tank.
+func (g *Game) TankCenter(player PlayerID) intersect.Point { return g.tanks[player].position }
 
 // TargetCenter returns the current target center.
 func (g *Game) TargetCenter() intersect.Point { return g.target }
 
 // Bullets returns the current bullet locations.
-func (g *Game) Bullets() []intersect.Point { return g.bullets }
+func (g *Game) Bullets() []intersect.Point {
+	p := make([]intersect.Point, len(g.bullets))
+	for i, b := range g.bullets {
+		p[i] = b.position
+	}
+	return p
+}
+
+// Ticks returns the number of time steps that have been simulated.
+func (g *Game) Ticks() int { return g.simTicks }
 
 // Smoke returns the current smoke locations.
 func (g *Game) Smoke() []intersect.Point {
@@ -94,43 +109,188 @@ func (g *Game) Smoke() []intersect.Point {
 	return p
 }
 
-func New() *Game {
+// New returns a game with one player that follows the rules in cfg, or an error if cfg is not
+// valid.
+func New(cfg Config) (*Game, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	g := &Game{
+		cfg,
 		// tank
-		intersect.Point{X: tankInitialX, Y: tankInitialY}, DirNone,
+		[]tank{{cfg.TankStarts[0], DirNone}},
 		// target
-		intersect.Point{X: targetX, Y: targetMinY}, DirDown,
+		intersect.Point{X: cfg.TargetX, Y: cfg.TargetMinY}, DirDown,
+		make([]intersect.Point, cfg.targetHistoryLen()),
 		nil, nil,
 		0,
 	}
+	g.targetHistory[0] = g.target
+	return g, nil
+}
+
+// MustNew is like New but panics if cfg is not valid. It is for rules that are known to be
+// valid, such as DefaultConfig or the rules of an existing game.
+func MustNew(cfg Config) *Game {
+	g, err := New(cfg)
+	if err != nil {
+		panic(err)
+	}
 	return g
 }
 
+// AddPlayer adds a tank at its start position and returns the new player's ID.
+func (g *Game) AddPlayer() PlayerID {
+	player := PlayerID(len(g.tanks))
+	start := g.cfg.TankStarts[int(player)%len(g.cfg.TankStarts)]
+	g.tanks = append(g.tanks, tank{start, DirNone})
+	return player
+}
+
 func (g *Game) Clone() *Game {
+	tanksClone := slices.Clone(g.tanks)
+	historyClone := slices.Clone(g.targetHistory)
 	bulletsClone := slices.Clone(g.bullets)
 	smokeClone := slices.Clone(g.smoke)
 	return &Game{
-		g.tank, g.tankDir, g.target, g.targetDir, bulletsClone, smokeClone, g.simTicks,
+		g.cfg, tanksClone, g.target, g.targetDir, historyClone, bulletsClone, smokeClone, g.simTicks,
+	}
+}
+
+// CopyTank sets the position and direction of player's tank to the tank in other.
+func (g *Game) CopyTank(other *Game, player PlayerID) {
+	g.tanks[player] = other.tanks[player]
+}
+
+// Interpolate returns a copy of from where the moving objects are blended between from and to.
+// t=0 is from and t=1 is to. The target and the tanks in both games are interpolated; bullets
+// move in a straight line so they are extrapolated from from, and the smoke does not move. The
+// caller replaces the tank controlled by the player with CopyTank.
+func Interpolate(from *Game, to *Game, t float64) *Game {
+	timeSteps := t * float64(to.simTicks-from.simTicks)
+	out := from.Extrapolate(timeSteps)
+	out.target = from.target.Lerp(to.target, t)
+	out.targetDir = to.targetDir
+	for i := range out.tanks {
+		if i < len(to.tanks) {
+			out.tanks[i].position = from.tanks[i].position.Lerp(to.tanks[i].position, t)
+			out.tanks[i].dir = to.tanks[i].dir
+		}
+	}
+	// players who joined after from
+	for i := len(out.tanks); i < len(to.tanks); i++ {
+		out.tanks = append(out.tanks, to.tanks[i])
+	}
+	return out
+}
+
+// Extrapolate returns a copy of g where the moving objects have moved for timeSteps, which may
+// be fractional. The simulation is not advanced: bullets do not hit the target and the smoke
+// does not disappear.
+func (g *Game) Extrapolate(timeSteps float64) *Game {
+	out := g.Clone()
+	for i := range out.tanks {
+		offset := out.tankOffset(out.tanks[i].dir)
+		out.tanks[i].position = out.tanks[i].position.Add(offset.Scale(timeSteps))
+		out.keepInWorld(i)
+	}
+
+	minY := g.cfg.TargetMinY
+	maxY := g.cfg.TargetMaxY
+	distance := timeSteps * g.cfg.perTimeStep(g.cfg.TargetMovePerSecond)
+	switch out.targetDir {
+	case DirDown:
+		out.target.Y += distance
+		if out.target.Y > maxY {
+			out.target.Y = maxY - (out.target.Y - maxY)
+			out.targetDir = DirUp
+		}
+	case DirUp:
+		out.target.Y -= distance
+		if out.target.Y < minY {
+			out.target.Y = minY + (minY - out.target.Y)
+			out.targetDir = DirDown
+		}
+	default:
+		panic("bad target direction")
 	}
+
+	out.bullets = out.bullets[:0]
+	bulletMove := g.cfg.perTimeStep(g.cfg.BulletMovePerSecond)
+	for _, b := range g.bullets {
+		b.position = b.position.Add(intersect.Point{X: timeSteps * bulletMove})
+		if b.position.X < g.cfg.WorldSize {
+			out.bullets = append(out.bullets, b)
+		}
+	}
+	return out
 }
 
 type Input struct {
 	TankDir Direction
 	Fire    bool
+	// ViewTick is the tick of the target the player saw when they fired. If it is set, the
+	// bullet's hits are tested against where the target was at that tick, up to MaxRewindMS in
+	// the past (lag compensation). Zero disables lag compensation.
+	ViewTick int
 }
 
-// ProcessInput processes the input from the player.
-func (g *Game) ProcessInput(i Input) {
-	g.tankDir = i.TankDir
+// ProcessInput processes the input from player.
+func (g *Game) ProcessInput(player PlayerID, i Input) {
+	if !(0 <= player && int(player) < len(g.tanks)) {
+		panic(fmt.Sprintf("BUG: invalid player %d", player))
+	}
+	g.tanks[player].dir = i.TankDir
 
 	if i.Fire {
-		g.bullets = append(g.bullets, g.tank)
+		rewindTicks := 0
+		if i.ViewTick > 0 {
+			rewindTicks = g.simTicks - i.ViewTick
+			if rewindTicks < 0 {
+				// the player saw a predicted target that is ahead of the game
+				rewindTicks = 0
+			} else if rewindTicks > g.cfg.maxRewindTicks() {
+				rewindTicks = g.cfg.maxRewindTicks()
+			}
+		}
+		g.bullets = append(g.bullets, bullet{g.tanks[player].position, rewindTicks, player})
+	}
+}
+
+// tankOffset returns how far a tank moving in dir moves in one time step.
+func (g *Game) tankOffset(dir Direction) intersect.Point {
+	tankMovePerTimeStep := g.cfg.perTimeStep(g.cfg.TankMovePerSecond)
+	switch dir {
+	case DirLeft:
+		return intersect.Point{X: -tankMovePerTimeStep, Y: 0}
+	case DirRight:
+		return intersect.Point{X: tankMovePerTimeStep, Y: 0}
+
+	case DirDown:
+		return intersect.Point{X: 0, Y: tankMovePerTimeStep}
+	case DirUp:
+		return intersect.Point{X: 0, Y: -tankMovePerTimeStep}
+
+	case DirNone:
+		// do nothing
+		return intersect.Point{}
+
+	default:
+		panic("unhandled direction")
+	}
+}
+
+// targetAt returns the target's position at tick, which must be at most maxRewindTicks()+1 ago.
+func (g *Game) targetAt(tick int) intersect.Point {
+	if tick < 0 {
+		tick = 0
 	}
+	return g.targetHistory[tick%len(g.targetHistory)]
 }
 
 // AdvanceSimulation advances the simulation to msSinceStart.
 func (g *Game) AdvanceSimulation(msSinceStart float64) {
-	ticksSinceStart := int(msSinceStart / TimeStepMS)
+	ticksSinceStart := int(msSinceStart / float64(g.cfg.TimeStepMS))
 
 	// advance physics simulation until we are "caught up"
 	// see https://gafferongames.com/post/fix_your_timestep/
@@ -139,64 +299,106 @@ func (g *Game) AdvanceSimulation(msSinceStart float64) {
 	}
 }
 
-func (g *Game) SimulateTimeStep() {
-	offsetX := 0.0
-	offsetY := 0.0
-
-	switch g.tankDir {
-	case DirLeft:
-		offsetX = -tankMovePerTimeStep
-	case DirRight:
-		offsetX = tankMovePerTimeStep
-
-	case DirDown:
-		offsetY = tankMovePerTimeStep
-	case DirUp:
-		offsetY = -tankMovePerTimeStep
+// collideTank pushes the tank of player out of the target and the other tanks it overlaps,
+// then keeps it inside the world.
+func (g *Game) collideTank(player int) {
+	t := &g.tanks[player]
+	if penetration, ok := intersect.BoxCircle(t.position, sprites.TankSize, g.target, targetRadius); ok {
+		t.position = t.position.Add(penetration)
+	}
+	for i := range g.tanks {
+		if i == player {
+			continue
+		}
+		penetration, ok := intersect.BoxBox(t.position, sprites.TankSize, g.tanks[i].position, sprites.TankSize)
+		if ok {
+			t.position = t.position.Add(penetration)
+		}
+	}
+	g.keepInWorld(player)
+}
 
-	case DirNone:
-		// do nothing
+// keepInWorld moves the tank of player back inside the world if it crossed an edge.
+func (g *Game) keepInWorld(player int) {
+	const half = sprites.TankSize / 2.0
+	p := &g.tanks[player].position
+	p.X = clamp(p.X, half, g.cfg.WorldSize-half)
+	p.Y = clamp(p.Y, half, g.cfg.WorldSize-half)
+}
 
-	default:
-		panic("unhandled direction")
+// clamp returns v limited to the range [low, high].
+func clamp(v float64, low float64, high float64) float64 {
+	if v < low {
+		return low
 	}
+	if v > high {
+		return high
+	}
+	return v
+}
 
-	g.tank.X += offsetX
-	g.tank.Y += offsetY
+// SimulateTimeStep advances the simulation by one time step. It returns the number of bullets
+// that hit the target.
+func (g *Game) SimulateTimeStep() int {
+	hits := 0
+	for i := range g.tanks {
+		g.tanks[i].position = g.tanks[i].position.Add(g.tankOffset(g.tanks[i].dir))
+		g.collideTank(i)
+	}
 
+	targetMove := g.cfg.perTimeStep(g.cfg.TargetMovePerSecond)
 	switch g.targetDir {
 	case DirDown:
-		g.target.Y += targetMovePerTimeStep
-		if g.target.Y > targetMaxY {
-			g.target.Y = targetMaxY
+		g.target.Y += targetMove
+		if g.target.Y > g.cfg.TargetMaxY {
+			g.target.Y = g.cfg.TargetMaxY
 			g.targetDir = DirUp
 		}
 	case DirUp:
-		g.target.Y -= targetMovePerTimeStep
-		if g.target.Y < targetMinY {
-			g.target.Y = targetMinY
+		g.target.Y -= targetMove
+		if g.target.Y < g.cfg.TargetMinY {
+			g.target.Y = g.cfg.TargetMinY
 			g.targetDir = DirDown
 		}
 	default:
 		panic("bad target direction")
 	}
+	// the target does not stop: it pushes the tanks it moved into
+	for i := range g.tanks {
+		g.collideTank(i)
+	}
+
+	nextTick := g.simTicks + 1
+	g.targetHistory[nextTick%len(g.targetHistory)] = g.target
 
+	bulletMove := g.cfg.perTimeStep(g.cfg.BulletMovePerSecond)
 	for i := 0; i < len(g.bullets); i++ {
-		g.bullets[i].X += bulletMovePerTimeStep
+		b := &g.bullets[i]
+		start := b.position
+		b.position = b.position.Add(intersect.Point{X: bulletMove})
 
 		shouldRemove := false
-		if g.bullets[i].X >= maxEdgeDimension {
+		if b.position.X >= g.cfg.WorldSize {
 			// bullet is off the screen: remove it
 			shouldRemove = true
 		}
 
-		// in testing: the point/box intersection is basically as good as the the path/box
-		// intersection and much simpler. It misses on RARE occasions
-		if intersect.PointBox(g.bullets[i], g.target, sprites.TargetSize) {
-			// bullet hit the target! remove it and add smoke
+		// lag compensation: test against the target where the player saw it
+		target := g.targetAt(nextTick - b.rewindTicks)
+		targetStart := g.targetAt(nextTick - b.rewindTicks - 1)
+
+		// test the whole path of the bullet during the time step against the moving target: fast
+		// bullets can pass through the target between the end of two time steps. The bullet's
+		// center touches the target when it is inside the target grown by the bullet's shape
+		hit, ok := intersect.PathMovingCapsuleHit(start, b.position, targetStart, target,
+			intersect.Point{X: bulletHalfLength}, targetRadius+bulletRadius)
+		if ok {
+			// bullet hit the target! remove it and add smoke where it hit
 			shouldRemove = true
-			g.smoke = append(g.smoke, smoke{g.bullets[i], 0})
-			log.Printf("hit! bullet = %s ; target = %s", g.bullets[i], g.target)
+			hits++
+			g.smoke = append(g.smoke, smoke{hit.Point, 0})
+			log.Printf("hit! player = %d ; bullet = %s ; target = %s ; rewind ticks = %d",
+				b.owner, hit.Point, target, b.rewindTicks)
 		}
 
 		if shouldRemove {
@@ -209,7 +411,7 @@ func (g *Game) SimulateTimeStep() {
 
 	for i := 0; i < len(g.smoke); i++ {
 		g.smoke[i].timeStepCount++
-		if g.smoke[i].timeStepCount >= smokeDisplayTimeSteps {
+		if g.smoke[i].timeStepCount >= g.cfg.smokeTimeSteps() {
 			last := len(g.smoke) - 1
 			g.smoke[last], g.smoke[i] = g.smoke[i], g.smoke[last]
 			g.smoke = g.smoke[:last]
@@ -218,4 +420,5 @@ func (g *Game) SimulateTimeStep() {
 	}
 
 	g.simTicks++
+	return hits
 }
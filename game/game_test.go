@@ -0,0 +1,411 @@
+package game
+
+import (
+	"math"
+	"testing"
+
+	"github.com/evanj/netgamesim/intersect"
+	"github.com/evanj/netgamesim/sprites"
+)
+
+// fireAndHit simulates ticks, fires with viewTick, and returns true if the bullet hit.
+func fireAndHit(ticks int, viewTick int) bool {
+	g := MustNew(DefaultConfig())
+	for g.simTicks < ticks {
+		g.SimulateTimeStep()
+	}
+	g.ProcessInput(0, Input{Fire: true, ViewTick: viewTick})
+	for len(g.bullets) > 0 {
+		g.SimulateTimeStep()
+	}
+	return len(g.smoke) > 0
+}
+
+func TestLagCompensation(t *testing.T) {
+	const rewindTicks = 10
+	const maxTicks = 300
+
+	// firing with lag compensation has the same result as firing when the player saw the target
+	hits := 0
+	uncompensatedDiffers := 0
+	for viewTick := 1; viewTick < maxTicks; viewTick++ {
+		expected := fireAndHit(viewTick, 0)
+		if expected {
+			hits++
+		}
+		compensated := fireAndHit(viewTick+rewindTicks, viewTick)
+		if compensated != expected {
+			t.Errorf("viewTick=%d: compensated hit=%t; expected %t", viewTick, compensated, expected)
+		}
+		if fireAndHit(viewTick+rewindTicks, 0) != expected {
+			uncompensatedDiffers++
+		}
+	}
+	if hits == 0 || uncompensatedDiffers == 0 {
+		t.Errorf("hits=%d uncompensatedDiffers=%d; test does not test anything", hits, uncompensatedDiffers)
+	}
+
+	// the rewind is limited
+	g := MustNew(DefaultConfig())
+	for i := 0; i < 2*g.cfg.maxRewindTicks(); i++ {
+		g.SimulateTimeStep()
+	}
+	g.ProcessInput(0, Input{Fire: true, ViewTick: 1})
+	g.ProcessInput(0, Input{Fire: true, ViewTick: g.simTicks + 5})
+	if g.bullets[0].rewindTicks != g.cfg.maxRewindTicks() || g.bullets[1].rewindTicks != 0 {
+		t.Errorf("rewindTicks=%d,%d; expected %d,0",
+			g.bullets[0].rewindTicks, g.bullets[1].rewindTicks, g.cfg.maxRewindTicks())
+	}
+}
+
+func TestPlayers(t *testing.T) {
+	g := MustNew(DefaultConfig())
+	baseline := g.Clone()
+	other := g.AddPlayer()
+	if g.Players() != 2 || other != 1 {
+		t.Fatalf("Players()=%d AddPlayer()=%d; expected 2 players", g.Players(), other)
+	}
+	if g.TankCenter(0) == g.TankCenter(other) {
+		t.Errorf("tanks start at the same position %s", g.TankCenter(0))
+	}
+
+	// each player moves their own tank and owns their bullets
+	start := g.Clone()
+	g.ProcessInput(0, Input{TankDir: DirDown})
+	g.ProcessInput(other, Input{TankDir: DirRight, Fire: true})
+	g.SimulateTimeStep()
+	if !(g.TankCenter(0).Y > start.TankCenter(0).Y && g.TankCenter(0).X == start.TankCenter(0).X) {
+		t.Errorf("player 0 tank=%s; expected to move down from %s", g.TankCenter(0), start.TankCenter(0))
+	}
+	if !(g.TankCenter(other).X > start.TankCenter(other).X &&
+		g.TankCenter(other).Y == start.TankCenter(other).Y) {
+		t.Errorf("player 1 tank=%s; expected to move right from %s",
+			g.TankCenter(other), start.TankCenter(other))
+	}
+	if len(g.bullets) != 1 || g.bullets[0].owner != other {
+		t.Errorf("bullets=%+v; expected one bullet fired by player 1", g.bullets)
+	}
+
+	// a delta against a baseline without the new player
+	delta, err := g.MarshalDelta(baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Game{}
+	if err := decoded.UnmarshalDelta(baseline, delta); err != nil {
+		t.Fatal(err)
+	}
+	if !sameState(g, decoded) {
+		t.Errorf("decoded=%+v; expected %+v", decoded, g)
+	}
+
+	// interpolation moves the other tanks and adds the player
+	half := Interpolate(baseline, g, 0.5)
+	if half.Players() != 2 || half.TankCenter(other) != g.TankCenter(other) {
+		t.Errorf("interpolated players=%d; expected the new player from the later game", half.Players())
+	}
+	expectedY := (baseline.TankCenter(0).Y + g.TankCenter(0).Y) / 2
+	if half.TankCenter(0).Y != expectedY {
+		t.Errorf("interpolated tank=%s; expected y=%f", half.TankCenter(0), expectedY)
+	}
+}
+
+func TestTankCollisions(t *testing.T) {
+	overlaps := func(a intersect.Point, aSize float64, b intersect.Point, bSize float64) bool {
+		_, ok := intersect.BoxBox(a, aSize, b, bSize)
+		return ok
+	}
+
+	// player 0 drives down into player 1's tank and stops touching it
+	g := MustNew(DefaultConfig())
+	other := g.AddPlayer()
+	start := g.TankCenter(other)
+	g.ProcessInput(0, Input{TankDir: DirDown})
+	for i := 0; i < 50; i++ {
+		g.SimulateTimeStep()
+		if overlaps(g.TankCenter(0), sprites.TankSize, g.TankCenter(other), sprites.TankSize) {
+			t.Fatalf("tick %d: tanks %s and %s overlap", g.Ticks(), g.TankCenter(0), g.TankCenter(other))
+		}
+	}
+	expectedY := start.Y - sprites.TankSize
+	if math.Abs(g.TankCenter(0).Y-expectedY) > 1e-9 || g.TankCenter(other) != start {
+		t.Errorf("tanks=%s,%s; expected player 0 stopped at y=%f and player 1 at %s",
+			g.TankCenter(0), g.TankCenter(other), expectedY, start)
+	}
+
+	// player 0 drives right into the target's path when the target comes back up: it blocks
+	// the tank
+	g = MustNew(DefaultConfig())
+	blocked := false
+	for i := 0; i < 200; i++ {
+		if i == 60 {
+			g.ProcessInput(0, Input{TankDir: DirRight})
+		}
+		before := g.TankCenter(0)
+		g.SimulateTimeStep()
+		// pushing the tank out of the round target can leave a rounding error
+		penetration, _ := intersect.BoxCircle(g.TankCenter(0), sprites.TankSize, g.TargetCenter(), targetRadius)
+		if math.Abs(penetration.X) > 1e-9 || math.Abs(penetration.Y) > 1e-9 {
+			t.Fatalf("tick %d: tank %s overlaps the target %s", g.Ticks(), g.TankCenter(0), g.TargetCenter())
+		}
+		if g.TankCenter(0).X < before.X+g.tankOffset(DirRight).X {
+			blocked = true
+		}
+	}
+	if !blocked {
+		t.Error("the target never blocked the tank; test does not test anything")
+	}
+}
+
+func TestWorldBounds(t *testing.T) {
+	const half = sprites.TankSize / 2.0
+	small := DefaultConfig()
+	small.WorldSize = 200
+	small.TankStarts = small.TankStarts[:1]
+	// the target moves along the bottom edge, out of the tank's way
+	small.TargetX = 100
+	small.TargetMinY = 170
+	small.TargetMaxY = 185
+	for _, cfg := range []Config{DefaultConfig(), small} {
+		size := cfg.WorldSize
+		for _, test := range []struct {
+			dir      Direction
+			expected func(start intersect.Point) intersect.Point
+		}{
+			{DirLeft, func(start intersect.Point) intersect.Point { return intersect.Point{X: half, Y: start.Y} }},
+			{DirUp, func(start intersect.Point) intersect.Point { return intersect.Point{X: start.X, Y: half} }},
+			{DirRight, func(start intersect.Point) intersect.Point { return intersect.Point{X: size - half, Y: start.Y} }},
+			{DirDown, func(start intersect.Point) intersect.Point { return intersect.Point{X: start.X, Y: size - half} }},
+		} {
+			g := MustNew(cfg)
+			start := g.TankCenter(0)
+			g.ProcessInput(0, Input{TankDir: test.dir})
+
+			// long enough to cross the world, and to get out of the target's way going right
+			for i := 0; i < 1000; i++ {
+				g.SimulateTimeStep()
+			}
+			expected := test.expected(start)
+			if g.TankCenter(0) != expected {
+				t.Errorf("size=%f dir=%s: tank=%s; expected stopped at the edge %s",
+					size, test.dir, g.TankCenter(0), expected)
+			}
+			extrapolated := g.Extrapolate(10).TankCenter(0)
+			if extrapolated != expected {
+				t.Errorf("size=%f dir=%s: Extrapolate moved the tank to %s; expected %s",
+					size, test.dir, extrapolated, expected)
+			}
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	// at 20 Hz, the tank moves the same distance per second in fewer time steps
+	quake := DefaultConfig()
+	quake.TimeStepMS = 50
+	for _, cfg := range []Config{DefaultConfig(), quake} {
+		g := MustNew(cfg)
+		start := g.TankCenter(0)
+		g.ProcessInput(0, Input{TankDir: DirDown})
+		g.AdvanceSimulation(1000)
+		moved := g.TankCenter(0).Y - start.Y
+		expected := float64(1000/cfg.TimeStepMS) * float64(cfg.TimeStepMS) * cfg.TankMovePerSecond / 1000
+		if g.Ticks() != 1000/cfg.TimeStepMS || math.Abs(moved-expected) > 1e-9 {
+			t.Errorf("TimeStepMS=%d: ticks=%d moved=%f; expected %d ticks and %f",
+				cfg.TimeStepMS, g.Ticks(), moved, 1000/cfg.TimeStepMS, expected)
+		}
+		if len(g.targetHistory) != MaxRewindMS/cfg.TimeStepMS+2 {
+			t.Errorf("TimeStepMS=%d: len(targetHistory)=%d; expected to cover MaxRewindMS",
+				cfg.TimeStepMS, len(g.targetHistory))
+		}
+	}
+
+	// slower bullets move less each time step
+	slow := DefaultConfig()
+	slow.BulletMovePerSecond /= 2
+	g := MustNew(DefaultConfig())
+	slowGame := MustNew(slow)
+	for _, g := range []*Game{g, slowGame} {
+		g.ProcessInput(0, Input{Fire: true})
+		g.SimulateTimeStep()
+	}
+	start := MustNew(DefaultConfig()).TankCenter(0)
+	if !(slowGame.Bullets()[0].X-start.X < g.Bullets()[0].X-start.X) {
+		t.Errorf("slow bullet=%s; expected behind the default bullet %s",
+			slowGame.Bullets()[0], g.Bullets()[0])
+	}
+
+	// the decoded game keeps the receiver's rules
+	data, err := slowGame.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := MustNew(slow)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Config().BulletMovePerSecond != slow.BulletMovePerSecond {
+		t.Errorf("decoded config=%+v; expected %+v", decoded.Config(), slow)
+	}
+
+	for name, change := range map[string]func(c *Config){
+		"timeStep":       func(c *Config) { c.TimeStepMS = 0 },
+		"negativeSpeed":  func(c *Config) { c.TankMovePerSecond = -1 },
+		"nanSpeed":       func(c *Config) { c.TargetMovePerSecond = math.NaN() },
+		"stoppedBullets": func(c *Config) { c.BulletMovePerSecond = 0 },
+		"infiniteSmoke":  func(c *Config) { c.SmokeDisplaySeconds = math.Inf(1) },
+		"worldSize":      func(c *Config) { c.WorldSize = sprites.TankSize - 1 },
+		"noTankStarts":   func(c *Config) { c.TankStarts = nil },
+		"tankStart":      func(c *Config) { c.TankStarts = []intersect.Point{{X: -75, Y: 75}} },
+		"targetX":        func(c *Config) { c.TargetX = c.WorldSize + 1 },
+		"targetY":        func(c *Config) { c.TargetMinY, c.TargetMaxY = c.TargetMaxY, c.TargetMinY },
+	} {
+		cfg := DefaultConfig()
+		change(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: Validate(%+v) should fail", name, cfg)
+		}
+		if g, err := New(cfg); err == nil || g != nil {
+			t.Errorf("%s: New(%+v)=%v, %v; expected an error", name, cfg, g, err)
+		}
+	}
+}
+
+// bulletHitsTarget returns true if the bullet at p touches the target at c.
+func bulletHitsTarget(p intersect.Point, c intersect.Point) bool {
+	return intersect.CapsuleCircle(intersect.Point{X: p.X - bulletHalfLength, Y: p.Y},
+		intersect.Point{X: p.X + bulletHalfLength, Y: p.Y}, bulletRadius, c, targetRadius)
+}
+
+// sampledHit returns true if a bullet moving p0 -> p1 touches the target moving c0 -> c1 at any
+// of many sampled times during the time step.
+func sampledHit(p0 intersect.Point, p1 intersect.Point, c0 intersect.Point, c1 intersect.Point) bool {
+	const samples = 200
+	for i := 0; i <= samples; i++ {
+		s := float64(i) / samples
+		if bulletHitsTarget(p0.Lerp(p1, s), c0.Lerp(c1, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBulletTunneling(t *testing.T) {
+	// bullets that pass through the target between two time steps must still hit it
+	pointMisses := 0
+	for _, bulletSpeed := range []float64{300, 900, 3000, 10000, 30000} {
+		for _, targetSpeed := range []float64{0, 400, 2000} {
+			cfg := DefaultConfig()
+			cfg.BulletMovePerSecond = bulletSpeed
+			cfg.TargetMovePerSecond = targetSpeed
+			// the tank is in the middle of the target's path; the target that does not move
+			// stays in front of it
+			cfg.TankStarts = []intersect.Point{{X: 75, Y: 250}}
+			if targetSpeed == 0 {
+				cfg.TargetMinY = 240
+			}
+
+			sampledHits := 0
+			for fireTick := 0; fireTick < 100; fireTick++ {
+				g := MustNew(cfg)
+				for g.Ticks() < fireTick {
+					g.SimulateTimeStep()
+				}
+				g.ProcessInput(0, Input{Fire: true})
+				for len(g.bullets) > 0 {
+					p0 := g.bullets[0].position
+					c0 := g.target
+					hits := g.SimulateTimeStep()
+					p1 := intersect.Point{X: p0.X + cfg.perTimeStep(bulletSpeed), Y: p0.Y}
+					c1 := g.target
+					if sampledHit(p0, p1, c0, c1) {
+						sampledHits++
+						if hits == 0 {
+							t.Errorf("bullet speed=%f target speed=%f fire tick=%d: bullet %s->%s missed target %s->%s",
+								bulletSpeed, targetSpeed, fireTick, p0, p1, c0, c1)
+						}
+						if !bulletHitsTarget(p1, c1) {
+							pointMisses++
+						}
+						break
+					}
+					if hits > 0 {
+						// grazing hits can be between the samples
+						break
+					}
+				}
+			}
+			if sampledHits == 0 {
+				t.Errorf("bullet speed=%f target speed=%f: no hits; test does not test anything",
+					bulletSpeed, targetSpeed)
+			}
+			if targetSpeed == 0 && sampledHits != 100 {
+				t.Errorf("bullet speed=%f: %d hits on the stationary target; expected 100",
+					bulletSpeed, sampledHits)
+			}
+		}
+	}
+	if pointMisses == 0 {
+		t.Error("testing only the end of the path never misses; test does not test anything")
+	}
+}
+
+func TestSmokeAtImpact(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TargetMovePerSecond = 0
+	cfg.TankStarts = []intersect.Point{{X: 75, Y: 250}}
+	cfg.TargetMinY = 250
+	// the front of the bullet touches the edge of the target
+	expected := intersect.Point{X: cfg.TargetX - targetRadius - sprites.BulletSize/2, Y: 250}
+	for _, bulletSpeed := range []float64{900, 30000} {
+		cfg.BulletMovePerSecond = bulletSpeed
+		g := MustNew(cfg)
+		g.ProcessInput(0, Input{Fire: true})
+		for len(g.bullets) > 0 {
+			g.SimulateTimeStep()
+		}
+		// the bullet ends the time step inside or past the target, but the smoke is where the
+		// bullet was when it hit
+		smoke := g.Smoke()
+		if len(smoke) != 1 || math.Abs(smoke[0].X-expected.X) > 1e-9 || smoke[0].Y != expected.Y {
+			t.Errorf("bullet speed=%f: smoke=%v; expected one at the impact point %s",
+				bulletSpeed, smoke, expected)
+		}
+	}
+}
+
+func TestCollisionShapes(t *testing.T) {
+	// the target is round: bullets that pass the corners of its bounding box miss, and the
+	// bullet's thickness counts
+	cfg := DefaultConfig()
+	cfg.TargetMovePerSecond = 0
+	cfg.TargetMinY = 250
+	for _, test := range []struct {
+		offsetY  float64
+		expected bool
+	}{
+		{0, true},
+		{-targetRadius, true},
+		{targetRadius + bulletRadius - 0.5, true},
+		{targetRadius + bulletRadius + 0.5, false},
+	} {
+		cfg.TankStarts = []intersect.Point{{X: 75, Y: 250 + test.offsetY}}
+		g := MustNew(cfg)
+		g.ProcessInput(0, Input{Fire: true})
+		hits := 0
+		for len(g.bullets) > 0 {
+			hits += g.SimulateTimeStep()
+		}
+		if (hits == 1) != test.expected {
+			t.Errorf("offset=%f: hits=%d; expected hit=%t", test.offsetY, hits, test.expected)
+		}
+	}
+
+	// a tank in the corner of the target's bounding box does not touch it
+	cfg.TankStarts = []intersect.Point{{X: cfg.TargetX - 22, Y: 250 - 22}}
+	g := MustNew(cfg)
+	g.SimulateTimeStep()
+	if g.TankCenter(0) != cfg.TankStarts[0] {
+		t.Errorf("tank=%s; expected it not to be pushed from %s", g.TankCenter(0), cfg.TankStarts[0])
+	}
+}
@@ -0,0 +1,206 @@
+// Package inputscript parses scripts of player input, so the same "player" can be used for
+// every run of the simulation.
+//
+// A script is a list of statements, separated by new lines or semicolons. Text after # is a
+// comment. The statements are:
+//
+//	tick N: ACTION[, ACTION...]  at time step N, do the actions: left, up, right, down or none
+//	                             sets the tank's direction until it is changed; fire fires once
+//	repeat N                     repeat the script every N time steps
+//	random fire P [seed S]       fire with probability P every time step, with random seed S
+//
+// For example: "tick 10: right; tick 40: fire; tick 60: none".
+package inputscript
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/evanj/netgamesim/game"
+)
+
+// the seed for random fire if the script does not set one
+const defaultSeed = 1
+
+// actions at one tick
+type event struct {
+	setDir bool
+	dir    game.Direction
+	fire   bool
+}
+
+// Script is a parsed input script.
+type Script struct {
+	events map[int]event
+	// the script repeats every period ticks; 0 does not repeat
+	period          int
+	fireProbability float64
+	seed            int64
+}
+
+// Parse parses a script.
+func Parse(text string) (*Script, error) {
+	s := &Script{map[int]event{}, 0, 0, defaultSeed}
+	lastEventTick := -1
+	for lineIndex, line := range strings.Split(text, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, statement := range strings.Split(line, ";") {
+			fields := strings.Fields(statement)
+			if len(fields) == 0 {
+				continue
+			}
+
+			var err error
+			switch fields[0] {
+			case "tick":
+				var tick int
+				tick, err = s.parseTick(statement)
+				if tick > lastEventTick {
+					lastEventTick = tick
+				}
+			case "repeat":
+				err = s.parseRepeat(fields)
+			case "random":
+				err = s.parseRandom(fields)
+			default:
+				err = fmt.Errorf("unknown statement %q", fields[0])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("inputscript: line %d: %w", lineIndex+1, err)
+			}
+		}
+	}
+
+	if s.period > 0 && lastEventTick >= s.period {
+		return nil, fmt.Errorf("inputscript: tick %d must be less than repeat %d",
+			lastEventTick, s.period)
+	}
+	return s, nil
+}
+
+// MustParse is like Parse but panics if the script is invalid.
+func MustParse(text string) *Script {
+	s, err := Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseNonNegative(field string) (int, error) {
+	v, err := strconv.Atoi(field)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid number %q", field)
+	}
+	return v, nil
+}
+
+// parseTick parses "tick N: ACTIONS" and returns N.
+func (s *Script) parseTick(statement string) (int, error) {
+	tickPart, actionPart, found := strings.Cut(statement, ":")
+	fields := strings.Fields(tickPart)
+	if !found || len(fields) != 2 {
+		return 0, fmt.Errorf("invalid tick statement %q: expected tick N: ACTIONS",
+			strings.TrimSpace(statement))
+	}
+	tick, err := parseNonNegative(fields[1])
+	if err != nil {
+		return 0, err
+	}
+
+	e := s.events[tick]
+	actions := strings.Split(actionPart, ",")
+	for _, action := range actions {
+		action = strings.TrimSpace(action)
+		if action == "fire" {
+			e.fire = true
+			continue
+		}
+		var dir game.Direction
+		if err := dir.UnmarshalText([]byte(action)); err != nil {
+			return 0, fmt.Errorf("tick %d: unknown action %q", tick, action)
+		}
+		if e.setDir && e.dir != dir {
+			return 0, fmt.Errorf("tick %d: conflicting directions %s and %s", tick, e.dir, dir)
+		}
+		e.setDir = true
+		e.dir = dir
+	}
+	s.events[tick] = e
+	return tick, nil
+}
+
+// parseRepeat parses "repeat N".
+func (s *Script) parseRepeat(fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid repeat statement: expected repeat N")
+	}
+	period, err := parseNonNegative(fields[1])
+	if err != nil {
+		return err
+	}
+	if period == 0 {
+		return fmt.Errorf("repeat must be at least 1")
+	}
+	s.period = period
+	return nil
+}
+
+// parseRandom parses "random fire P [seed S]".
+func (s *Script) parseRandom(fields []string) error {
+	if !(len(fields) == 3 || (len(fields) == 5 && fields[3] == "seed")) || fields[1] != "fire" {
+		return fmt.Errorf("invalid random statement: expected random fire P [seed S]")
+	}
+	p, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil || !(0 <= p && p <= 1) {
+		return fmt.Errorf("invalid probability %q", fields[2])
+	}
+	s.fireProbability = p
+	if len(fields) == 5 {
+		s.seed, err = strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid seed %q", fields[4])
+		}
+	}
+	return nil
+}
+
+// Player returns a new player for the script, starting at tick 0.
+func (s *Script) Player() *Player {
+	return &Player{s, rand.New(rand.NewSource(s.seed)), 0, game.DirNone}
+}
+
+// Player plays a script, tracking the tank's direction and the random fire.
+type Player struct {
+	script *Script
+	rng    *rand.Rand
+	// the next tick that has not been played
+	nextTick int
+	dir      game.Direction
+}
+
+// Input returns the input at tick. Fire is true if the script fired at any tick since the
+// previous call, so calling Input less often than every tick does not lose shots. tick must
+// not decrease.
+func (p *Player) Input(tick int) game.Input {
+	fire := false
+	for ; p.nextTick <= tick; p.nextTick++ {
+		scriptTick := p.nextTick
+		if p.script.period > 0 {
+			scriptTick %= p.script.period
+		}
+		e := p.script.events[scriptTick]
+		if e.setDir {
+			p.dir = e.dir
+		}
+		fire = fire || e.fire
+		if p.script.fireProbability > 0 && p.rng.Float64() < p.script.fireProbability {
+			fire = true
+		}
+	}
+	return game.Input{TankDir: p.dir, Fire: fire}
+}
@@ -0,0 +1,100 @@
+package inputscript
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/evanj/netgamesim/game"
+	"golang.org/x/exp/slices"
+)
+
+func TestPlayer(t *testing.T) {
+	s, err := Parse("tick 10: right; tick 40: fire\n# comment\ntick 60: none, fire # stop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := s.Player()
+	for _, test := range []struct {
+		tick     int
+		expected game.Input
+	}{
+		{0, game.Input{}},
+		{9, game.Input{}},
+		{10, game.Input{TankDir: game.DirRight}},
+		{40, game.Input{TankDir: game.DirRight, Fire: true}},
+		{40, game.Input{TankDir: game.DirRight}},
+		{59, game.Input{TankDir: game.DirRight}},
+		// the shot at 60 is not lost by skipping ticks
+		{1000, game.Input{TankDir: game.DirNone, Fire: true}},
+	} {
+		input := p.Input(test.tick)
+		if input != test.expected {
+			t.Errorf("Input(%d)=%+v; expected %+v", test.tick, input, test.expected)
+		}
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	p := MustParse("repeat 20\ntick 0: up, fire\ntick 10: down").Player()
+	for tick := 0; tick < 100; tick++ {
+		input := p.Input(tick)
+		expectedDir := game.DirUp
+		if tick%20 >= 10 {
+			expectedDir = game.DirDown
+		}
+		if input.TankDir != expectedDir || input.Fire != (tick%20 == 0) {
+			t.Errorf("Input(%d)=%+v; expected dir=%s fire=%t",
+				tick, input, expectedDir, tick%20 == 0)
+		}
+	}
+}
+
+func TestRandomFire(t *testing.T) {
+	fires := func(script string) []int {
+		p := MustParse(script).Player()
+		var out []int
+		for tick := 0; tick < 1000; tick++ {
+			if p.Input(tick).Fire {
+				out = append(out, tick)
+			}
+		}
+		return out
+	}
+
+	seed1 := fires("random fire 0.1 seed 1")
+	if !(50 < len(seed1) && len(seed1) < 150) {
+		t.Errorf("len(fires)=%d; expected about 100", len(seed1))
+	}
+	if !slices.Equal(fires("random fire 0.1 seed 1"), seed1) {
+		t.Error("the same seed must fire at the same ticks")
+	}
+	if slices.Equal(fires("random fire 0.1 seed 2"), seed1) {
+		t.Error("different seeds should fire at different ticks")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, script := range []string{
+		"jump",
+		"tick 10 right",
+		"tick -1: right",
+		"tick x: right",
+		"tick 10: sideways",
+		"tick 10: left, right",
+		"repeat 0",
+		"repeat 10\ntick 10: right",
+		"random fire 2",
+		"random fire 0.5 seed x",
+		"random walk 0.5",
+	} {
+		_, err := Parse(script)
+		if err == nil {
+			t.Errorf("Parse(%q) should fail", script)
+		}
+	}
+
+	_, err := Parse("tick 1: up\ntick 2: jump")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("err=%v; expected the line number", err)
+	}
+}
@@ -27,73 +27,357 @@ func (p Point) String() string {
 
 // PointBox returns true if p is contained in the AABB with center and diameter.
 func PointBox(p Point, center Point, diameter float64) bool {
-	// compute bounding box
-	x0 := center.X - diameter/2
-	y0 := center.Y - diameter/2
-	x1 := center.X + diameter/2
-	y1 := center.Y + diameter/2
+	return NewAABB(center, diameter).Contains(p)
+}
+
+// BoxBox returns true if the AABB with center a and diameter aDiameter overlaps the AABB with
+// center b and diameter bDiameter. Boxes that only touch do not overlap. If they overlap, it
+// also returns the penetration: the shortest vector that moves a out of b, along the axis where
+// they overlap the least.
+func BoxBox(a Point, aDiameter float64, b Point, bDiameter float64) (Point, bool) {
+	// the boxes overlap on an axis if the distance between the centers is less than the sum of
+	// the half diameters
+	halfSum := (aDiameter + bDiameter) / 2
+	d := a.Sub(b)
+	overlapX := halfSum - math.Abs(d.X)
+	overlapY := halfSum - math.Abs(d.Y)
+	if overlapX <= 0 || overlapY <= 0 {
+		return Point{}, false
+	}
+
+	// push a away from b's center; if the centers are the same, push in the negative direction
+	if overlapX < overlapY {
+		if d.X <= 0 {
+			overlapX = -overlapX
+		}
+		return Point{overlapX, 0}, true
+	}
+	if d.Y <= 0 {
+		overlapY = -overlapY
+	}
+	return Point{0, overlapY}, true
+}
 
-	// hit if point is in the box
-	return (x0 <= p.X && p.X <= x1) &&
-		(y0 <= p.Y && p.Y <= y1)
+// Hit describes where a path intersects a box.
+type Hit struct {
+	// TEnter and TExit are the fractions of the path where it enters and leaves the box, in the
+	// range [0, 1].
+	TEnter float64
+	TExit  float64
+	// Point is where the path enters the box.
+	Point Point
+	// Normal is the unit normal of the box's edge where the path enters, pointing out of the
+	// box. It is zero if the path starts inside the box.
+	Normal Point
 }
 
 // PathBox returns true if path segment p0 -> p1 intersects the AABB with center and diameter.
 func PathBox(p0 Point, p1 Point, center Point, diameter float64) bool {
+	_, intersects := PathBoxHit(p0, p1, center, diameter)
+	return intersects
+}
+
+// PathBoxHit returns where path segment p0 -> p1 intersects the AABB with center and diameter,
+// and true if it does. Paths that only touch the box's edges intersect it. A path with p0 == p1
+// is a point, which intersects the box if it is inside it. If any argument is NaN, the path
+// does not intersect the box.
+func PathBoxHit(p0 Point, p1 Point, center Point, diameter float64) (Hit, bool) {
 	// uses the "slab intersection" algorithm: using the linear interpolation form of the line
 	// x = x0 + t(x1 - x0)
 	// y = y0 + t(y1 - y0)
 	// then compute tMin / tMax after comparing against all 4 edges
 	// http://www.pbr-book.org/3ed-2018/Shapes/Basic_Shape_Interface.html#RayndashBoundsIntersections
 
-	// compute bounding box
-	x0 := center.X - diameter/2
-	y0 := center.Y - diameter/2
-	x1 := center.X + diameter/2
-	y1 := center.Y + diameter/2
-
-	xVec := p1.X - p0.X
-	yVec := p1.Y - p0.Y
+	box := NewAABB(center, diameter)
+	vec := p1.Sub(p0)
 
-	// intersection with top line y0
-	t0 := (y0 - p0.Y) / yVec
-	// intersection with bottom line y1
-	t1 := (y1 - p0.Y) / yVec
-	if t0 > t1 {
-		t1, t0 = t0, t1
+	// NaN arguments make the bounding box or the vectors NaN; so does a path with the same
+	// infinite coordinate at both ends
+	if hasNaN(p0.X, p0.Y, box.Min.X, box.Min.Y, box.Max.X, box.Max.Y, vec.X, vec.Y) {
+		return Hit{}, false
 	}
 
-	tMin := t0
-	tMax := t1
-
-	// intersection with left line x0
-	t0 = (x0 - p0.X) / xVec
-	// intersection with right line x1
-	t1 = (x1 - p0.X) / xVec
-	if t0 > t1 {
-		t1, t0 = t0, t1
+	// intersection with top line and bottom line
+	tMin, tMax, normal, ok := slab(p0.Y, vec.Y, box.Min.Y, box.Max.Y, Point{0, -1}, Point{0, 1})
+	if !ok {
+		return Hit{}, false
+	}
+	// intersection with left line and right line
+	t0, t1, xNormal, ok := slab(p0.X, vec.X, box.Min.X, box.Max.X, Point{-1, 0}, Point{1, 0})
+	if !ok {
+		return Hit{}, false
 	}
 
-	// intersect tMin/tMax with t0/t1
-	tMin = math.Max(tMin, t0)
+	// intersect tMin/tMax with t0/t1: the path enters through the edge it crosses last
+	if t0 > tMin {
+		tMin = t0
+		normal = xNormal
+	}
 	tMax = math.Min(tMax, t1)
 
 	if tMin > tMax {
 		// no intersection of parameters: this means the ray does not intersect the box
-		return false
+		return Hit{}, false
 	}
 
 	// intersect tMin/tMax with [0, 1]
-	tMin = math.Max(tMin, 0)
+	if tMin < 0 {
+		// the path starts inside the box
+		tMin = 0
+		normal = Point{}
+	}
 	tMax = math.Min(tMax, 1)
 
 	if tMin > tMax {
 		// no intersection of parameters in the range [0, 1]
-		return false
+		return Hit{}, false
+	}
+
+	hit := Hit{tMin, tMax, p0.Lerp(p1, tMin), normal}
+	debugf("%s->%s intersects box %s-%s; hit=%+v", p0, p1, box.Min, box.Max, hit)
+	return hit, true
+}
+
+// hasNaN returns true if any of values is NaN.
+func hasNaN(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// slab returns the range of t where p + t*vec is between the lines low and high, the normal of
+// the line where it enters, and true if the range is not empty. If vec is zero, the path is
+// parallel to the lines: it is between them for every t or for none, and the normal is zero.
+func slab(p float64, vec float64, low float64, high float64, lowNormal Point, highNormal Point) (
+	float64, float64, Point, bool) {
+
+	if vec == 0 {
+		// dividing by zero would return NaN when p is on a line
+		if low <= p && p <= high {
+			return math.Inf(-1), math.Inf(1), Point{}, true
+		}
+		return 0, 0, Point{}, false
+	}
+
+	tLow := (low - p) / vec
+	tHigh := (high - p) / vec
+	if tLow > tHigh {
+		return tHigh, tLow, highNormal, true
+	}
+	return tLow, tHigh, lowNormal, true
+}
+
+// PathMovingBox returns true if a point moving along path segment p0 -> p1 intersects the AABB
+// with diameter while its center moves from center0 to center1 during the same time. Both move
+// in a straight line at a constant speed.
+func PathMovingBox(p0 Point, p1 Point, center0 Point, center1 Point, diameter float64) bool {
+	_, intersects := PathMovingBoxHit(p0, p1, center0, center1, diameter)
+	return intersects
+}
+
+// PathMovingBoxHit is PathMovingBox that also returns where the point hits the box. Hit.Point is
+// where the point is when it enters the moving box.
+func PathMovingBoxHit(p0 Point, p1 Point, center0 Point, center1 Point, diameter float64) (Hit, bool) {
+	// relative to the box, the point moves from p0 - center0 to p1 - center1: test that path
+	// against the box where it ends
+	start := p0.Sub(center0).Add(center1)
+	hit, intersects := PathBoxHit(start, p1, center1, diameter)
+	if !intersects {
+		return Hit{}, false
+	}
+	hit.Point = p0.Lerp(p1, hit.TEnter)
+	return hit, true
+}
+
+// closestOnSegment returns the point on segment p0 -> p1 that is closest to p.
+func closestOnSegment(p0 Point, p1 Point, p Point) Point {
+	vec := p1.Sub(p0)
+	lengthSquared := vec.Dot(vec)
+	if lengthSquared == 0 {
+		// the segment is a point
+		return p0
+	}
+	// project p on the line, then limit it to the segment
+	t := p.Sub(p0).Dot(vec) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+	return p0.Lerp(p1, t)
+}
+
+// distanceSquared returns the squared distance between a and b.
+func distanceSquared(a Point, b Point) float64 {
+	d := a.Sub(b)
+	return d.Dot(d)
+}
+
+// CirclePoint returns true if p is contained in the circle with center and radius.
+func CirclePoint(center Point, radius float64, p Point) bool {
+	return distanceSquared(center, p) <= radius*radius
+}
+
+// CircleSegment returns true if segment p0 -> p1 intersects the circle with center and radius.
+func CircleSegment(center Point, radius float64, p0 Point, p1 Point) bool {
+	return CirclePoint(center, radius, closestOnSegment(p0, p1, center))
+}
+
+// CapsuleCircle returns true if the capsule intersects the circle with center and
+// circleRadius. The capsule is the area covered by a circle with radius moving from p0 to p1.
+func CapsuleCircle(p0 Point, p1 Point, radius float64, center Point, circleRadius float64) bool {
+	return CircleSegment(center, radius+circleRadius, p0, p1)
+}
+
+// BoxCircle returns true if the AABB with center box and diameter overlaps the circle with
+// center and radius. Shapes that only touch do not overlap. If they overlap, it also returns the
+// penetration: the shortest vector that moves the box out of the circle.
+func BoxCircle(box Point, diameter float64, center Point, radius float64) (Point, bool) {
+	bounds := NewAABB(box, diameter)
+	closest := Point{
+		math.Max(bounds.Min.X, math.Min(bounds.Max.X, center.X)),
+		math.Max(bounds.Min.Y, math.Min(bounds.Max.Y, center.Y)),
+	}
+	if distanceSquared(closest, center) >= radius*radius {
+		return Point{}, false
+	}
+
+	if closest != center {
+		// the center is outside the box: push the box away from it
+		offset := closest.Sub(center)
+		distance := offset.Length()
+		return offset.Scale((radius - distance) / distance), true
+	}
+
+	// the center is inside the box: push the box along the axis that needs the least movement,
+	// like BoxBox
+	return BoxBox(box, diameter, center, 2*radius)
+}
+
+// PathCircleHit returns where path segment p0 -> p1 intersects the circle with center and
+// radius, and true if it does. Like PathBoxHit, a path with p0 == p1 is a point.
+func PathCircleHit(p0 Point, p1 Point, center Point, radius float64) (Hit, bool) {
+	// solve |p0 + t(p1 - p0) - center|^2 = radius^2 for t
+	vec := p1.Sub(p0)
+	start := p0.Sub(center)
+	a := vec.Dot(vec)
+	b := 2 * vec.Dot(start)
+	c := start.Dot(start) - radius*radius
+
+	var tMin, tMax float64
+	if a == 0 {
+		// the path is a point: the quadratic has no solutions
+		if !(c <= 0) {
+			return Hit{}, false
+		}
+		tMin = math.Inf(-1)
+		tMax = math.Inf(1)
+	} else {
+		discriminant := b*b - 4*a*c
+		if !(discriminant >= 0) {
+			// the line misses the circle
+			return Hit{}, false
+		}
+		root := math.Sqrt(discriminant)
+		tMin = (-b - root) / (2 * a)
+		tMax = (-b + root) / (2 * a)
+	}
+
+	normal := Point{}
+	if tMin >= 0 {
+		if radius > 0 {
+			normal = start.Add(vec.Scale(tMin)).Scale(1 / radius)
+		}
+	} else {
+		// the path starts inside the circle
+		tMin = 0
+	}
+	tMax = math.Min(tMax, 1)
+	if tMin > tMax {
+		// the circle is before or after the path
+		return Hit{}, false
+	}
+	return Hit{tMin, tMax, p0.Lerp(p1, tMin), normal}, true
+}
+
+// PathCapsuleHit returns where path segment p0 -> p1 intersects the capsule, and true if it
+// does. The capsule is the area covered by a circle with radius moving from a0 to a1.
+func PathCapsuleHit(p0 Point, p1 Point, a0 Point, a1 Point, radius float64) (Hit, bool) {
+	if hasNaN(p0.X, p0.Y, p1.X-p0.X, p1.Y-p0.Y, a0.X, a0.Y, a1.X-a0.X, a1.Y-a0.Y, radius) {
+		return Hit{}, false
+	}
+	// the capsule is the union of the circles at its ends and the rectangle between them. It is
+	// convex, so the path is inside it between the first entry and the last exit of the parts
+	hit, intersects := PathCircleHit(p0, p1, a0, radius)
+	if end, ok := PathCircleHit(p0, p1, a1, radius); ok {
+		hit, intersects = unionHit(hit, intersects, end), true
 	}
 
-	debugf("%s->%s intersects box (%f,%f)x(%f,%f); t=[%f,%f] point min = (%f,%f)",
-		p0, p1, x0, y0, x1, x1,
-		tMin, tMax, p0.X+xVec*tMin, p0.Y+yVec*tMin)
-	return true
+	axis := a1.Sub(a0)
+	length := axis.Length()
+	if length == 0 {
+		// the capsule is a circle
+		return hit, intersects
+	}
+	// in the rectangle's coordinates, x is along the axis from a0 and y is across it
+	u := axis.Scale(1 / length)
+	v := Point{-u.Y, u.X}
+	local := func(p Point) Point {
+		d := p.Sub(a0)
+		return Point{d.Dot(u), d.Dot(v)}
+	}
+	start := local(p0)
+	end := local(p1)
+	tMin, tMax, normal, ok := slab(start.Y, end.Y-start.Y, -radius, radius, v.Scale(-1), v)
+	if !ok {
+		return hit, intersects
+	}
+	t0, t1, xNormal, ok := slab(start.X, end.X-start.X, 0, length, u.Scale(-1), u)
+	if !ok {
+		return hit, intersects
+	}
+	if t0 > tMin {
+		tMin = t0
+		normal = xNormal
+	}
+	tMax = math.Min(math.Min(tMax, t1), 1)
+	if tMin < 0 {
+		tMin = 0
+		normal = Point{}
+	}
+	if tMin > tMax {
+		return hit, intersects
+	}
+	middle := Hit{tMin, tMax, p0.Lerp(p1, tMin), normal}
+	return unionHit(hit, intersects, middle), true
+}
+
+// unionHit returns the hit that covers a and b, where a is only valid if aOK. The path enters
+// where it enters the first one.
+func unionHit(a Hit, aOK bool, b Hit) Hit {
+	if !aOK {
+		return b
+	}
+	out := a
+	if b.TEnter < a.TEnter {
+		out = b
+	}
+	out.TExit = math.Max(a.TExit, b.TExit)
+	return out
+}
+
+// PathMovingCapsuleHit is PathCapsuleHit for a capsule that moves in a straight line at a
+// constant speed while the point moves along p0 -> p1. The capsule is the area covered by a
+// circle with radius moving between center - halfAxis and center + halfAxis, where its center
+// moves from center0 to center1. Hit.Point is where the point is when it enters the capsule.
+func PathMovingCapsuleHit(p0 Point, p1 Point, center0 Point, center1 Point, halfAxis Point,
+	radius float64) (Hit, bool) {
+
+	// like PathMovingBoxHit: test the path relative to the capsule where it ends
+	start := p0.Sub(center0).Add(center1)
+	hit, intersects := PathCapsuleHit(start, p1, center1.Sub(halfAxis), center1.Add(halfAxis), radius)
+	if !intersects {
+		return Hit{}, false
+	}
+	hit.Point = p0.Lerp(p1, hit.TEnter)
+	return hit, true
 }
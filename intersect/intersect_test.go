@@ -1,6 +1,10 @@
 package intersect
 
-import "testing"
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
 
 func TestPathBox(t *testing.T) {
 	boxCenter := Point{10.0, 10.0}
@@ -21,6 +25,12 @@ func TestPathBox(t *testing.T) {
 
 		// point not in the box
 		path{Point{0, 0}, Point{0, 0}},
+
+		// on the line of an edge, but before the box: 0/0 is NaN
+		path{Point{0, 7.5}, Point{5, 7.5}},
+		path{Point{12.5, 0}, Point{12.5, 5}},
+		// point on the line of an edge, outside the box
+		path{Point{0, 7.5}, Point{0, 7.5}},
 	}
 	for i, testPath := range shouldNotIntersect {
 		for _, path := range []path{testPath, path{testPath.p2, testPath.p1}} {
@@ -43,6 +53,15 @@ func TestPathBox(t *testing.T) {
 		path{Point{9, 9}, Point{11, 11}},
 		// contained point
 		path{Point{12, 12}, Point{12, 12}},
+
+		// along an edge
+		path{Point{0, 7.5}, Point{20, 7.5}},
+		path{Point{12.5, 0}, Point{12.5, 20}},
+		// ends on an edge
+		path{Point{0, 7.5}, Point{7.5, 7.5}},
+		// points on an edge and a corner
+		path{Point{7.5, 10}, Point{7.5, 10}},
+		path{Point{12.5, 12.5}, Point{12.5, 12.5}},
 	}
 	for i, testPath := range shouldIntersect {
 		for _, path := range []path{testPath, path{testPath.p2, testPath.p1}} {
@@ -55,3 +74,503 @@ func TestPathBox(t *testing.T) {
 	}
 
 }
+
+func TestBoxBox(t *testing.T) {
+	center := Point{10, 10}
+	const size = 10.0
+
+	tests := []struct {
+		other       Point
+		otherSize   float64
+		overlaps    bool
+		penetration Point
+	}{
+		// separated and touching boxes do not overlap
+		{Point{30, 10}, 10, false, Point{}},
+		{Point{20, 10}, 10, false, Point{}},
+		{Point{20, 20}, 10, false, Point{}},
+		{Point{10, 0}, 10, false, Point{}},
+
+		// pushed out along the axis with the least overlap
+		{Point{18, 10}, 10, true, Point{-2, 0}},
+		{Point{2, 11}, 10, true, Point{2, 0}},
+		{Point{9, 17}, 10, true, Point{0, -3}},
+		{Point{11, 1}, 10, true, Point{0, 1}},
+		{Point{12, 11}, 2, true, Point{-4, 0}},
+
+		// contained box with the same center
+		{Point{10, 10}, 4, true, Point{0, -7}},
+	}
+	for i, test := range tests {
+		penetration, overlaps := BoxBox(center, size, test.other, test.otherSize)
+		if overlaps != test.overlaps || penetration != test.penetration {
+			t.Errorf("%d: BoxBox(%s, %s size=%f)=%s, %t; expected %s, %t",
+				i, center, test.other, test.otherSize, penetration, overlaps, test.penetration, test.overlaps)
+		}
+
+		// moving the box by the penetration leaves the boxes touching
+		if overlaps {
+			moved := Point{center.X + penetration.X, center.Y + penetration.Y}
+			if _, overlaps := BoxBox(moved, size, test.other, test.otherSize); overlaps {
+				t.Errorf("%d: moved box %s still overlaps %s", i, moved, test.other)
+			}
+		}
+	}
+}
+
+func TestPathMovingBox(t *testing.T) {
+	const boxSize = 5.0
+	right := []Point{{0, 10}, {20, 10}}
+
+	tests := []struct {
+		center0    Point
+		center1    Point
+		intersects bool
+	}{
+		// a box that does not move is the same as PathBox
+		{Point{10, 10}, Point{10, 10}, true},
+		{Point{10, 20}, Point{10, 20}, false},
+
+		// the box crosses the path at the same time as the point, but is not on the path at
+		// the start or end
+		{Point{10, 0}, Point{10, 20}, true},
+		{Point{10, 20}, Point{10, 0}, true},
+
+		// the box ends on the path, but arrives after the point has passed
+		{Point{5, -10}, Point{5, 10}, false},
+		// the box moves away along the path in front of the point
+		{Point{13, 10}, Point{40, 10}, false},
+		// the box moves towards the point
+		{Point{40, 10}, Point{15, 10}, true},
+	}
+	for i, test := range tests {
+		intersects := PathMovingBox(right[0], right[1], test.center0, test.center1, boxSize)
+		if intersects != test.intersects {
+			t.Errorf("%d: %s->%s box %s->%s: intersects=%t; expected %t",
+				i, right[0], right[1], test.center0, test.center1, intersects, test.intersects)
+		}
+	}
+}
+
+func TestPathBoxHit(t *testing.T) {
+	boxCenter := Point{10.0, 10.0}
+	const boxSize = 4.0
+
+	tests := []struct {
+		p0  Point
+		p1  Point
+		hit Hit
+	}{
+		// from each side
+		{Point{0, 10}, Point{20, 10}, Hit{0.4, 0.6, Point{8, 10}, Point{-1, 0}}},
+		{Point{20, 10}, Point{0, 10}, Hit{0.4, 0.6, Point{12, 10}, Point{1, 0}}},
+		{Point{10, 0}, Point{10, 20}, Hit{0.4, 0.6, Point{10, 8}, Point{0, -1}}},
+		{Point{10, 20}, Point{10, 0}, Hit{0.4, 0.6, Point{10, 12}, Point{0, 1}}},
+
+		// diagonal through the side it reaches last
+		{Point{0, 9}, Point{10, 11}, Hit{0.8, 1, Point{8, 10.6}, Point{-1, 0}}},
+		// ends inside the box
+		{Point{10, 0}, Point{10, 10}, Hit{0.8, 1, Point{10, 8}, Point{0, -1}}},
+		// starts inside the box: no entry edge
+		{Point{10, 10}, Point{20, 10}, Hit{0, 0.2, Point{10, 10}, Point{}}},
+	}
+	for i, test := range tests {
+		hit, intersects := PathBoxHit(test.p0, test.p1, boxCenter, boxSize)
+		if !intersects || !closeHit(hit, test.hit) {
+			t.Errorf("%d: PathBoxHit(%s->%s)=%+v, %t; expected %+v",
+				i, test.p0, test.p1, hit, intersects, test.hit)
+		}
+	}
+
+	if hit, intersects := PathBoxHit(Point{0, 0}, Point{20, 0}, boxCenter, boxSize); intersects {
+		t.Errorf("PathBoxHit of a path that misses=%+v; expected no hit", hit)
+	}
+
+	// the moving box hits the point where it is when it enters
+	hit, intersects := PathMovingBoxHit(Point{0, 10}, Point{20, 10}, Point{10, 0}, Point{10, 20}, boxSize)
+	if !intersects || hit.Point.Y != 10 || !(8 <= hit.Point.X && hit.Point.X < 10) {
+		t.Errorf("PathMovingBoxHit=%+v, %t; expected a hit on the path before the center", hit, intersects)
+	}
+}
+
+// closeHit returns true if the fields of a and b are within floating point error.
+func closeHit(a Hit, b Hit) bool {
+	const epsilon = 1e-9
+	for _, pair := range [][2]float64{
+		{a.TEnter, b.TEnter}, {a.TExit, b.TExit}, {a.Point.X, b.Point.X}, {a.Point.Y, b.Point.Y},
+		{a.Normal.X, b.Normal.X}, {a.Normal.Y, b.Normal.Y},
+	} {
+		if math.Abs(pair[0]-pair[1]) > epsilon {
+			return false
+		}
+	}
+	return true
+}
+
+// sampledPath returns true if any of many points sampled along p0 -> p1 is inside a shape.
+func sampledPath(p0 Point, p1 Point, inside func(p Point) bool) bool {
+	const samples = 1000
+	for i := 0; i <= samples; i++ {
+		s := float64(i) / samples
+		if inside(Point{p0.X + s*(p1.X-p0.X), p0.Y + s*(p1.Y-p0.Y)}) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampledPathBox returns true if any of many points sampled along p0 -> p1 is in the box.
+func sampledPathBox(p0 Point, p1 Point, center Point, diameter float64) bool {
+	return sampledPath(p0, p1, func(p Point) bool { return PointBox(p, center, diameter) })
+}
+
+// checkPathBox compares PathBoxHit with points sampled along the path, and checks that the hit
+// is consistent.
+func checkPathBox(t *testing.T, p0 Point, p1 Point, center Point, diameter float64) {
+	t.Helper()
+	hit, intersects := PathBoxHit(p0, p1, center, diameter)
+	if intersects != PathBox(p0, p1, center, diameter) {
+		t.Fatalf("PathBoxHit=%t; PathBox must be the same", intersects)
+	}
+
+	// sampled points that are clearly in the box must intersect; rounding can put points on
+	// the edge either side of it
+	magnitude := 1.0
+	for _, v := range []float64{p0.X, p0.Y, p1.X, p1.Y, center.X, center.Y, diameter} {
+		magnitude = math.Max(magnitude, math.Abs(v))
+	}
+	epsilon := 1e-9 * magnitude
+	if !intersects {
+		if diameter > 2*epsilon && sampledPathBox(p0, p1, center, diameter-2*epsilon) {
+			t.Errorf("%s->%s box %s diameter %f: sampled points intersect; PathBoxHit does not",
+				p0, p1, center, diameter)
+		}
+		return
+	}
+
+	if !(0 <= hit.TEnter && hit.TEnter <= hit.TExit && hit.TExit <= 1) {
+		t.Errorf("%s->%s box %s diameter %f: hit=%+v; expected 0 <= TEnter <= TExit <= 1",
+			p0, p1, center, diameter, hit)
+	}
+	switch hit.Normal {
+	case Point{}, Point{-1, 0}, Point{1, 0}, Point{0, -1}, Point{0, 1}:
+	default:
+		t.Errorf("hit=%+v; expected the normal of an edge", hit)
+	}
+	if hit.Normal == (Point{}) && hit.TEnter != 0 {
+		t.Errorf("hit=%+v; expected a normal for a path that starts outside the box", hit)
+	}
+
+	// the entry point and the middle of the part in the box are in the box
+	middleT := (hit.TEnter + hit.TExit) / 2
+	middle := Point{p0.X + middleT*(p1.X-p0.X), p0.Y + middleT*(p1.Y-p0.Y)}
+	for _, p := range []Point{hit.Point, middle} {
+		if !PointBox(p, center, diameter+2*epsilon) {
+			t.Errorf("%s->%s box %s diameter %f: hit=%+v; %s is not in the box",
+				p0, p1, center, diameter, hit, p)
+		}
+	}
+}
+
+func TestPathBoxRandom(t *testing.T) {
+	center := Point{10, 10}
+	const diameter = 5.0
+	// coordinates on the lines of the edges find the parallel and degenerate cases
+	edges := []float64{7.5, 12.5}
+	rng := rand.New(rand.NewSource(1))
+	coordinate := func() float64 {
+		if rng.Intn(3) == 0 {
+			return edges[rng.Intn(len(edges))]
+		}
+		return rng.Float64()*30 - 5
+	}
+	for i := 0; i < 20000; i++ {
+		p0 := Point{coordinate(), coordinate()}
+		p1 := Point{coordinate(), coordinate()}
+		switch rng.Intn(4) {
+		case 0:
+			// a point
+			p1 = p0
+		case 1:
+			// horizontal
+			p1.Y = p0.Y
+		case 2:
+			// vertical
+			p1.X = p0.X
+		}
+		checkPathBox(t, p0, p1, center, diameter)
+	}
+}
+
+func FuzzPathBox(f *testing.F) {
+	// degenerate cases: points and paths along the edges of the box at (10, 10) with diameter 5
+	f.Add(0.0, 0.0, 20.0, 20.0, 10.0, 10.0, 5.0)
+	f.Add(0.0, 7.5, 20.0, 7.5, 10.0, 10.0, 5.0)
+	f.Add(0.0, 7.5, 5.0, 7.5, 10.0, 10.0, 5.0)
+	f.Add(7.5, 10.0, 7.5, 10.0, 10.0, 10.0, 5.0)
+	f.Add(12.5, 0.0, 12.5, 20.0, 10.0, 10.0, 5.0)
+	f.Add(0.0, 0.0, 0.0, 0.0, 0.0, 0.0, 0.0)
+
+	f.Fuzz(func(t *testing.T, x0 float64, y0 float64, x1 float64, y1 float64, cx float64,
+		cy float64, diameter float64) {
+
+		// limit the range so sampling finds the intersections
+		const limit = 1e6
+		for _, v := range []float64{x0, y0, x1, y1, cx, cy, diameter} {
+			if !(-limit <= v && v <= limit) {
+				return
+			}
+		}
+		checkPathBox(t, Point{x0, y0}, Point{x1, y1}, Point{cx, cy}, math.Abs(diameter))
+	})
+}
+
+func TestCircle(t *testing.T) {
+	center := Point{10, 10}
+	const radius = 5.0
+
+	for i, test := range []struct {
+		p        Point
+		expected bool
+	}{
+		{Point{10, 10}, true},
+		{Point{15, 10}, true},
+		{Point{13, 14}, true},
+		// in the bounding box, but not the circle
+		{Point{14, 14}, false},
+		{Point{10, 15.01}, false},
+	} {
+		if CirclePoint(center, radius, test.p) != test.expected {
+			t.Errorf("%d: CirclePoint(%s)=%t; expected %t", i, test.p, !test.expected, test.expected)
+		}
+	}
+
+	for i, test := range []struct {
+		p0       Point
+		p1       Point
+		expected bool
+	}{
+		// through, ending in, and touching the circle
+		{Point{0, 10}, Point{20, 10}, true},
+		{Point{0, 0}, Point{10, 10}, true},
+		{Point{0, 15}, Point{20, 15}, true},
+		// the line is close to the circle, but the segment ends before it
+		{Point{0, 10}, Point{4, 10}, false},
+		// a corner of the bounding box
+		{Point{14, 20}, Point{20, 14}, false},
+		// a point
+		{Point{12, 12}, Point{12, 12}, true},
+		{Point{20, 20}, Point{20, 20}, false},
+	} {
+		if CircleSegment(center, radius, test.p0, test.p1) != test.expected {
+			t.Errorf("%d: CircleSegment(%s->%s)=%t; expected %t",
+				i, test.p0, test.p1, !test.expected, test.expected)
+		}
+		// a capsule is a segment with a radius
+		if CapsuleCircle(test.p0, test.p1, 0, center, radius) != test.expected {
+			t.Errorf("%d: CapsuleCircle(%s->%s, 0)=%t; expected %t",
+				i, test.p0, test.p1, !test.expected, test.expected)
+		}
+	}
+	if !CapsuleCircle(Point{0, 17}, Point{20, 17}, 2, center, radius) {
+		t.Error("CapsuleCircle with a radius that reaches the circle should intersect")
+	}
+	if CapsuleCircle(Point{0, 17.1}, Point{20, 17.1}, 2, center, radius) {
+		t.Error("CapsuleCircle with a radius that does not reach the circle should not intersect")
+	}
+}
+
+func TestBoxCircle(t *testing.T) {
+	center := Point{10, 10}
+	const radius = 5.0
+
+	tests := []struct {
+		box         Point
+		diameter    float64
+		overlaps    bool
+		penetration Point
+	}{
+		// separated, touching, and in the corner of the circle's bounding box
+		{Point{20, 10}, 4, false, Point{}},
+		{Point{17, 10}, 4, false, Point{}},
+		{Point{16, 16}, 4, false, Point{}},
+
+		// pushed away from the center
+		{Point{16, 10}, 4, true, Point{1, 0}},
+		{Point{10, 4}, 4, true, Point{0, -1}},
+		// the corner (11, 11) is sqrt(2) from the center
+		{Point{16, 16}, 10, true, Point{(5 - math.Sqrt2) / math.Sqrt2, (5 - math.Sqrt2) / math.Sqrt2}},
+
+		// the center is inside the box
+		{Point{12, 10}, 10, true, Point{8, 0}},
+	}
+	for i, test := range tests {
+		penetration, overlaps := BoxCircle(test.box, test.diameter, center, radius)
+		if overlaps != test.overlaps || math.Abs(penetration.X-test.penetration.X) > 1e-9 ||
+			math.Abs(penetration.Y-test.penetration.Y) > 1e-9 {
+			t.Errorf("%d: BoxCircle(%s diameter=%f)=%s, %t; expected %s, %t",
+				i, test.box, test.diameter, penetration, overlaps, test.penetration, test.overlaps)
+		}
+
+		// moving the box by the penetration leaves the shapes touching
+		if overlaps {
+			moved := Point{test.box.X + penetration.X, test.box.Y + penetration.Y}
+			if _, overlaps := BoxCircle(moved, test.diameter, center, radius-1e-9); overlaps {
+				t.Errorf("%d: moved box %s still overlaps", i, moved)
+			}
+		}
+	}
+}
+
+func TestPathCapsuleHit(t *testing.T) {
+	a0 := Point{10, 10}
+	a1 := Point{20, 10}
+	const radius = 2.0
+
+	tests := []struct {
+		p0  Point
+		p1  Point
+		hit Hit
+	}{
+		// into the end circles
+		{Point{0, 10}, Point{10, 10}, Hit{0.8, 1, Point{8, 10}, Point{-1, 0}}},
+		{Point{30, 10}, Point{20, 10}, Hit{0.8, 1, Point{22, 10}, Point{1, 0}}},
+		// into the sides
+		{Point{15, 0}, Point{15, 20}, Hit{0.4, 0.6, Point{15, 8}, Point{0, -1}}},
+		{Point{15, 20}, Point{15, 0}, Hit{0.4, 0.6, Point{15, 12}, Point{0, 1}}},
+		// along the axis
+		{Point{0, 10}, Point{30, 10}, Hit{8.0 / 30, 22.0 / 30, Point{8, 10}, Point{-1, 0}}},
+		// starts inside
+		{Point{15, 10}, Point{15, 20}, Hit{0, 0.2, Point{15, 10}, Point{}}},
+	}
+	for i, test := range tests {
+		hit, intersects := PathCapsuleHit(test.p0, test.p1, a0, a1, radius)
+		if !intersects || !closeHit(hit, test.hit) {
+			t.Errorf("%d: PathCapsuleHit(%s->%s)=%+v, %t; expected %+v",
+				i, test.p0, test.p1, hit, intersects, test.hit)
+		}
+	}
+	for i, miss := range [][2]Point{
+		// past the rounded corner
+		{Point{5, 5}, Point{9, 6}},
+		{Point{0, 13}, Point{30, 13}},
+		{Point{0, 10}, Point{7, 10}},
+	} {
+		if hit, intersects := PathCapsuleHit(miss[0], miss[1], a0, a1, radius); intersects {
+			t.Errorf("%d: PathCapsuleHit(%s->%s)=%+v; expected no hit", i, miss[0], miss[1], hit)
+		}
+	}
+
+	// a capsule with a0 == a1 is a circle
+	hit, intersects := PathCapsuleHit(Point{0, 10}, Point{10, 10}, a0, a0, radius)
+	circleHit, circleIntersects := PathCircleHit(Point{0, 10}, Point{10, 10}, a0, radius)
+	if !intersects || !circleIntersects || hit != circleHit {
+		t.Errorf("PathCapsuleHit=%+v, %t; expected the circle's %+v, %t",
+			hit, intersects, circleHit, circleIntersects)
+	}
+
+	// the moving capsule hits the point where it is when it enters
+	hit, intersects = PathMovingCapsuleHit(Point{0, 10}, Point{20, 10}, Point{10, 0}, Point{10, 20},
+		Point{3, 0}, radius)
+	if !intersects || hit.Point.Y != 10 || !(5 <= hit.Point.X && hit.Point.X < 10) {
+		t.Errorf("PathMovingCapsuleHit=%+v, %t; expected a hit on the path", hit, intersects)
+	}
+}
+
+func TestPathMovingCapsule(t *testing.T) {
+	const radius = 2.5
+	right := []Point{{0, 10}, {20, 10}}
+
+	// the same cases as TestPathMovingBox
+	tests := []struct {
+		center0    Point
+		center1    Point
+		intersects bool
+	}{
+		// a capsule that does not move is the same as PathCapsuleHit
+		{Point{10, 10}, Point{10, 10}, true},
+		{Point{10, 20}, Point{10, 20}, false},
+
+		// the capsule crosses the path at the same time as the point, but is not on the path at
+		// the start or end
+		{Point{10, 0}, Point{10, 20}, true},
+		{Point{10, 20}, Point{10, 0}, true},
+
+		// the capsule ends on the path, but arrives after the point has passed
+		{Point{5, -10}, Point{5, 10}, false},
+		// the capsule moves away along the path in front of the point
+		{Point{13, 10}, Point{40, 10}, false},
+		// the capsule moves towards the point
+		{Point{40, 10}, Point{15, 10}, true},
+	}
+	for i, test := range tests {
+		for _, halfAxis := range []Point{{}, {1, 0}, {0, 1}} {
+			_, intersects := PathMovingCapsuleHit(right[0], right[1], test.center0, test.center1,
+				halfAxis, radius)
+			if intersects != test.intersects {
+				t.Errorf("%d: %s->%s capsule %s->%s half axis %s: intersects=%t; expected %t",
+					i, right[0], right[1], test.center0, test.center1, halfAxis, intersects,
+					test.intersects)
+			}
+		}
+	}
+}
+
+func TestPathCapsuleRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	coordinate := func() float64 {
+		return rng.Float64()*30 - 5
+	}
+	for i := 0; i < 5000; i++ {
+		p0 := Point{coordinate(), coordinate()}
+		p1 := Point{coordinate(), coordinate()}
+		a0 := Point{coordinate(), coordinate()}
+		a1 := Point{coordinate(), coordinate()}
+		switch rng.Intn(4) {
+		case 0:
+			// a point
+			p1 = p0
+		case 1:
+			// a circle
+			a1 = a0
+		case 2:
+			// parallel to the axis
+			p1 = Point{p0.X + a1.X - a0.X, p0.Y + a1.Y - a0.Y}
+		}
+		radius := rng.Float64() * 5
+
+		const epsilon = 1e-9
+		hit, intersects := PathCapsuleHit(p0, p1, a0, a1, radius)
+		if !intersects {
+			inside := func(p Point) bool { return CircleSegment(p, radius-epsilon, a0, a1) }
+			if radius > epsilon && sampledPath(p0, p1, inside) {
+				t.Errorf("%s->%s capsule %s->%s radius %f: sampled points intersect; PathCapsuleHit does not",
+					p0, p1, a0, a1, radius)
+			}
+			continue
+		}
+		if !(0 <= hit.TEnter && hit.TEnter <= hit.TExit && hit.TExit <= 1) {
+			t.Errorf("hit=%+v; expected 0 <= TEnter <= TExit <= 1", hit)
+		}
+		length := math.Sqrt(hit.Normal.X*hit.Normal.X + hit.Normal.Y*hit.Normal.Y)
+		if !(length == 0 && hit.TEnter == 0 || math.Abs(length-1) < 1e-6) {
+			t.Errorf("hit=%+v; expected a unit normal, or none if the path starts inside", hit)
+		}
+		middleT := (hit.TEnter + hit.TExit) / 2
+		middle := Point{p0.X + middleT*(p1.X-p0.X), p0.Y + middleT*(p1.Y-p0.Y)}
+		for _, p := range []Point{hit.Point, middle} {
+			if !CircleSegment(p, radius+1e-6, a0, a1) {
+				t.Errorf("%s->%s capsule %s->%s radius %f: hit=%+v; %s is not in the capsule",
+					p0, p1, a0, a1, radius, hit, p)
+			}
+		}
+	}
+}
+
+func BenchmarkPathCapsuleHit(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		hit, _ := PathCapsuleHit(Point{0, float64(i & 31)}, Point{100, 10}, Point{46, 10},
+			Point{54, 10}, 17)
+		sinkFloat = hit.TEnter
+	}
+}
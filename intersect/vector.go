@@ -0,0 +1,98 @@
+package intersect
+
+import "math"
+
+// Add returns the vector p + q.
+func (p Point) Add(q Point) Point {
+	return Point{p.X + q.X, p.Y + q.Y}
+}
+
+// Sub returns the vector p - q.
+func (p Point) Sub(q Point) Point {
+	return Point{p.X - q.X, p.Y - q.Y}
+}
+
+// Scale returns the vector p multiplied by s.
+func (p Point) Scale(s float64) Point {
+	return Point{p.X * s, p.Y * s}
+}
+
+// Dot returns the dot product of p and q.
+func (p Point) Dot(q Point) float64 {
+	return p.X*q.X + p.Y*q.Y
+}
+
+// Cross returns the z component of the cross product of p and q: it is positive if q is
+// counterclockwise from p, in coordinates where y points up.
+func (p Point) Cross(q Point) float64 {
+	return p.X*q.Y - p.Y*q.X
+}
+
+// Length returns the length of the vector p.
+func (p Point) Length() float64 {
+	return math.Hypot(p.X, p.Y)
+}
+
+// Normalize returns the unit vector in the direction of p, or the zero vector if p is zero.
+func (p Point) Normalize() Point {
+	length := p.Length()
+	if length == 0 {
+		return Point{}
+	}
+	return Point{p.X / length, p.Y / length}
+}
+
+// Lerp returns the linear interpolation between p and q: p when t=0, q when t=1.
+func (p Point) Lerp(q Point, t float64) Point {
+	return Point{p.X + t*(q.X-p.X), p.Y + t*(q.Y-p.Y)}
+}
+
+// Distance returns the distance between p and q.
+func (p Point) Distance(q Point) float64 {
+	return q.Sub(p).Length()
+}
+
+// Rotate returns p rotated by radians around the origin, counterclockwise in coordinates where
+// y points up.
+func (p Point) Rotate(radians float64) Point {
+	sin, cos := math.Sincos(radians)
+	return Point{p.X*cos - p.Y*sin, p.X*sin + p.Y*cos}
+}
+
+// AABB is an axis-aligned bounding box: the points between Min and Max, including the edges.
+type AABB struct {
+	Min Point
+	Max Point
+}
+
+// NewAABB returns the box with center and diameter.
+func NewAABB(center Point, diameter float64) AABB {
+	half := Point{diameter / 2, diameter / 2}
+	return AABB{center.Sub(half), center.Add(half)}
+}
+
+// Contains returns true if p is inside the box or on its edges.
+func (b AABB) Contains(p Point) bool {
+	return (b.Min.X <= p.X && p.X <= b.Max.X) &&
+		(b.Min.Y <= p.Y && p.Y <= b.Max.Y)
+}
+
+// Intersects returns true if b and other have a point in common. Boxes that touch intersect.
+func (b AABB) Intersects(other AABB) bool {
+	return (b.Min.X <= other.Max.X && other.Min.X <= b.Max.X) &&
+		(b.Min.Y <= other.Max.Y && other.Min.Y <= b.Max.Y)
+}
+
+// Expand returns b grown by margin on every side. A negative margin shrinks it.
+func (b AABB) Expand(margin float64) AABB {
+	m := Point{margin, margin}
+	return AABB{b.Min.Sub(m), b.Max.Add(m)}
+}
+
+// Union returns the smallest box that contains b and other.
+func (b AABB) Union(other AABB) AABB {
+	return AABB{
+		Point{math.Min(b.Min.X, other.Min.X), math.Min(b.Min.Y, other.Min.Y)},
+		Point{math.Max(b.Max.X, other.Max.X), math.Max(b.Max.Y, other.Max.Y)},
+	}
+}
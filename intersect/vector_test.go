@@ -0,0 +1,176 @@
+package intersect
+
+import (
+	"math"
+	"testing"
+)
+
+func closePoint(a Point, b Point) bool {
+	const epsilon = 1e-9
+	return math.Abs(a.X-b.X) <= epsilon && math.Abs(a.Y-b.Y) <= epsilon
+}
+
+func TestPointVector(t *testing.T) {
+	p := Point{3, 4}
+	q := Point{-1, 2}
+	for i, test := range []struct {
+		result   Point
+		expected Point
+	}{
+		{p.Add(q), Point{2, 6}},
+		{p.Sub(q), Point{4, 2}},
+		{p.Scale(2), Point{6, 8}},
+		{p.Scale(0), Point{}},
+		{p.Normalize(), Point{0.6, 0.8}},
+		{Point{0, -5}.Normalize(), Point{0, -1}},
+		// the zero vector has no direction
+		{Point{}.Normalize(), Point{}},
+		{p.Lerp(q, 0), p},
+		{p.Lerp(q, 1), q},
+		{p.Lerp(q, 0.5), Point{1, 3}},
+		{p.Lerp(q, 2), Point{-5, 0}},
+		{Point{1, 0}.Rotate(math.Pi / 2), Point{0, 1}},
+		{Point{1, 0}.Rotate(-math.Pi / 2), Point{0, -1}},
+		{p.Rotate(math.Pi), Point{-3, -4}},
+		{p.Rotate(2 * math.Pi), p},
+	} {
+		if !closePoint(test.result, test.expected) {
+			t.Errorf("%d: result=%s; expected %s", i, test.result, test.expected)
+		}
+	}
+
+	for i, test := range []struct {
+		result   float64
+		expected float64
+	}{
+		{p.Dot(q), 5},
+		{p.Dot(Point{-4, 3}), 0},
+		{p.Cross(q), 10},
+		{q.Cross(p), -10},
+		{p.Cross(p.Scale(2)), 0},
+		{p.Length(), 5},
+		{Point{}.Length(), 0},
+		{p.Rotate(1).Length(), 5},
+		{p.Distance(q), math.Sqrt(20)},
+		{q.Distance(p), math.Sqrt(20)},
+		{p.Distance(p), 0},
+	} {
+		if math.Abs(test.result-test.expected) > 1e-9 {
+			t.Errorf("%d: result=%f; expected %f", i, test.result, test.expected)
+		}
+	}
+}
+
+func TestAABB(t *testing.T) {
+	box := NewAABB(Point{10, 10}, 4)
+	if box != (AABB{Point{8, 8}, Point{12, 12}}) {
+		t.Fatalf("NewAABB=%+v", box)
+	}
+
+	for _, test := range []struct {
+		p        Point
+		expected bool
+	}{
+		{Point{10, 10}, true},
+		// edges and corners are inside
+		{Point{8, 10}, true},
+		{Point{12, 12}, true},
+		{Point{7.9, 10}, false},
+		{Point{10, 12.1}, false},
+		{Point{0, 0}, false},
+	} {
+		if box.Contains(test.p) != test.expected {
+			t.Errorf("%+v.Contains(%s)=%t; expected %t", box, test.p, !test.expected, test.expected)
+		}
+	}
+
+	for _, test := range []struct {
+		other    AABB
+		expected bool
+	}{
+		{box, true},
+		// contained and containing
+		{NewAABB(Point{10, 10}, 1), true},
+		{NewAABB(Point{10, 10}, 100), true},
+		{NewAABB(Point{13, 13}, 4), true},
+		// touching edges and corners
+		{NewAABB(Point{14, 10}, 4), true},
+		{NewAABB(Point{14, 14}, 4), true},
+		{NewAABB(Point{14.1, 10}, 4), false},
+		// overlapping on only one axis
+		{NewAABB(Point{10, 20}, 4), false},
+		{NewAABB(Point{0, 10}, 4), false},
+	} {
+		if box.Intersects(test.other) != test.expected || test.other.Intersects(box) != test.expected {
+			t.Errorf("%+v.Intersects(%+v); expected %t", box, test.other, test.expected)
+		}
+	}
+
+	for i, test := range []struct {
+		result   AABB
+		expected AABB
+	}{
+		{box.Expand(1), AABB{Point{7, 7}, Point{13, 13}}},
+		{box.Expand(0), box},
+		{box.Expand(-2), AABB{Point{10, 10}, Point{10, 10}}},
+		{box.Union(box), box},
+		{box.Union(NewAABB(Point{10, 10}, 2)), box},
+		{box.Union(AABB{Point{0, 9}, Point{1, 20}}), AABB{Point{0, 8}, Point{12, 20}}},
+		{AABB{Point{20, 0}, Point{21, 1}}.Union(box), AABB{Point{8, 0}, Point{21, 12}}},
+	} {
+		if test.result != test.expected {
+			t.Errorf("%d: result=%+v; expected %+v", i, test.result, test.expected)
+		}
+	}
+}
+
+// sinkPoint and sinkFloat keep the compiler from removing the benchmarked calls.
+var sinkPoint Point
+var sinkFloat float64
+
+func BenchmarkPointAdd(b *testing.B) {
+	p := Point{3, 4}
+	for i := 0; i < b.N; i++ {
+		p = p.Add(Point{1, -1})
+	}
+	sinkPoint = p
+}
+
+func BenchmarkPointLerp(b *testing.B) {
+	p := Point{3, 4}
+	q := Point{-1, 2}
+	for i := 0; i < b.N; i++ {
+		sinkPoint = p.Lerp(q, float64(i&1023)/1024)
+	}
+}
+
+func BenchmarkPointNormalize(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sinkPoint = Point{3, float64(i & 1023)}.Normalize()
+	}
+}
+
+func BenchmarkPointRotate(b *testing.B) {
+	p := Point{3, 4}
+	for i := 0; i < b.N; i++ {
+		sinkPoint = p.Rotate(float64(i&1023) / 1024)
+	}
+}
+
+func BenchmarkPointDistance(b *testing.B) {
+	p := Point{3, 4}
+	for i := 0; i < b.N; i++ {
+		sinkFloat = p.Distance(Point{-1, float64(i & 1023)})
+	}
+}
+
+func BenchmarkAABBIntersects(b *testing.B) {
+	box := NewAABB(Point{10, 10}, 4)
+	intersects := 0
+	for i := 0; i < b.N; i++ {
+		if box.Intersects(NewAABB(Point{float64(i & 31), 10}, 4)) {
+			intersects++
+		}
+	}
+	sinkFloat = float64(intersects)
+}
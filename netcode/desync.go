@@ -0,0 +1,43 @@
+package netcode
+
+// DesyncStats counts the ticks where the client's predicted game was different from the game
+// computed from the server's state, by comparing checksums.
+type DesyncStats struct {
+	// Checked is the number of predicted ticks compared to a tick replayed from a server state.
+	Checked int
+	// Desyncs is the number of server states that showed the prediction was different.
+	Desyncs int
+	// FirstTick is the first tick where the prediction was different, or 0 if it never was.
+	FirstTick int
+	// ChecksumErrors is the number of server states that decoded to a different checksum than
+	// the server sent. They are dropped.
+	ChecksumErrors int
+}
+
+// checksumHistory records the checksums of the last maxBaselines ticks.
+type checksumHistory struct {
+	// ticks[i] is the tick of sums[i]; indexed by tick % maxBaselines
+	ticks []int
+	sums  []uint64
+}
+
+func newChecksumHistory() checksumHistory {
+	h := checksumHistory{make([]int, maxBaselines), make([]uint64, maxBaselines)}
+	for i := range h.ticks {
+		h.ticks[i] = -1
+	}
+	return h
+}
+
+func (h checksumHistory) record(tick int, sum uint64) {
+	h.ticks[tick%maxBaselines] = tick
+	h.sums[tick%maxBaselines] = sum
+}
+
+// lookup returns the checksum of tick, or false if it was not recorded or is too old.
+func (h checksumHistory) lookup(tick int) (uint64, bool) {
+	if h.ticks[tick%maxBaselines] != tick {
+		return 0, false
+	}
+	return h.sums[tick%maxBaselines], true
+}
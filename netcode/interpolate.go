@@ -0,0 +1,114 @@
+package netcode
+
+import "github.com/evanj/netgamesim/game"
+
+// DefaultMaxExtrapolateMS is the default limit on how far past the last state an Interpolator
+// will extrapolate.
+const DefaultMaxExtrapolateMS = 250
+
+// offsetSmoothing is the weight of a new measurement of the server clock offset
+const offsetSmoothing = 0.1
+
+// InterpolationStats counts how the interpolator rendered frames.
+type InterpolationStats struct {
+	// Interpolated is the number of frames that were blended between two states.
+	Interpolated int
+	// Extrapolated is the number of frames rendered after the last state, because the next state
+	// had not arrived.
+	Extrapolated int
+}
+
+// Interpolator buffers states from the server, and renders them DelayMS in the past by
+// blending between the two buffered states on either side of the render time. This hides
+// the gaps between states at the cost of showing objects further in the past. If the states
+// run out, it extrapolates from the last state for up to MaxExtrapolateMS.
+type Interpolator struct {
+	// DelayMS is how far in the past to render. Zero disables interpolation.
+	DelayMS float64
+	// MaxExtrapolateMS limits extrapolation past the last state. If it is zero,
+	// DefaultMaxExtrapolateMS is used.
+	MaxExtrapolateMS float64
+
+	// sorted by Ticks()
+	states []*game.Game
+	// estimate of the server's time minus the client's time
+	offsetMS float64
+	stats    InterpolationStats
+}
+
+// Stats returns the counts of interpolated and extrapolated frames.
+func (ip *Interpolator) Stats() InterpolationStats {
+	return ip.stats
+}
+
+func stateMS(state *game.Game) float64 {
+	return float64(state.Ticks() * state.Config().TimeStepMS)
+}
+
+// Add buffers a state that arrived at nowMS. States that are older than the newest state are
+// ignored. States that are more than DelayMS plus the extrapolation limit older than the newest
+// state are discarded, even if Render is not called.
+func (ip *Interpolator) Add(nowMS float64, state *game.Game) {
+	if len(ip.states) > 0 && state.Ticks() <= ip.states[len(ip.states)-1].Ticks() {
+		return
+	}
+
+	// smooth the clock offset so network jitter does not make objects jump around
+	offsetMS := stateMS(state) - nowMS
+	if len(ip.states) == 0 {
+		ip.offsetMS = offsetMS
+	} else {
+		ip.offsetMS += (offsetMS - ip.offsetMS) * offsetSmoothing
+	}
+	ip.states = append(ip.states, state)
+
+	// Render never goes back further than this: keep the last state before it
+	oldestMS := stateMS(state) - ip.DelayMS - ip.maxExtrapolateMS()
+	first := 0
+	for first+1 < len(ip.states) && stateMS(ip.states[first+1]) <= oldestMS {
+		first++
+	}
+	ip.states = ip.states[first:]
+}
+
+// maxExtrapolateMS returns the limit on extrapolation past the last state.
+func (ip *Interpolator) maxExtrapolateMS() float64 {
+	if ip.MaxExtrapolateMS == 0 {
+		return DefaultMaxExtrapolateMS
+	}
+	return ip.MaxExtrapolateMS
+}
+
+// Render returns the state to show at nowMS, or nil if no states have arrived.
+func (ip *Interpolator) Render(nowMS float64) *game.Game {
+	if len(ip.states) == 0 {
+		return nil
+	}
+	renderMS := nowMS + ip.offsetMS - ip.DelayMS
+
+	// discard states that are no longer needed: keep the last state before renderMS
+	first := 0
+	for first+1 < len(ip.states) && stateMS(ip.states[first+1]) <= renderMS {
+		first++
+	}
+	ip.states = ip.states[first:]
+
+	from := ip.states[0]
+	if renderMS <= stateMS(from) {
+		// the render time is before the first state: show it without changes
+		return from.Clone()
+	}
+	if len(ip.states) == 1 {
+		extrapolateMS := renderMS - stateMS(from)
+		if extrapolateMS > ip.maxExtrapolateMS() {
+			extrapolateMS = ip.maxExtrapolateMS()
+		}
+		ip.stats.Extrapolated++
+		return from.Extrapolate(extrapolateMS / float64(from.Config().TimeStepMS))
+	}
+
+	to := ip.states[1]
+	t := (renderMS - stateMS(from)) / (stateMS(to) - stateMS(from))
+	ip.stats.Interpolated++
+	return game.Interpolate(from, to, t)
+}
@@ -0,0 +1,98 @@
+package netcode
+
+import (
+	"math"
+	"testing"
+
+	"github.com/evanj/netgamesim/game"
+)
+
+func TestInterpolator(t *testing.T) {
+	g := game.MustNew(game.DefaultConfig())
+	var states []*game.Game
+	for i := 0; i < 3; i++ {
+		states = append(states, g.Clone())
+		for j := 0; j < 10; j++ {
+			g.SimulateTimeStep()
+		}
+	}
+	const stepMS = 10 * game.DefaultTimeStepMS
+
+	ip := &Interpolator{DelayMS: stepMS}
+	if ip.Render(0) != nil {
+		t.Error("Render without states should return nil")
+	}
+	// states arrive exactly when they are sent
+	ip.Add(0, states[0])
+	ip.Add(stepMS, states[1])
+
+	// render half way between the first two states
+	out := ip.Render(stepMS + stepMS/2)
+	y0 := states[0].TargetCenter().Y
+	y1 := states[1].TargetCenter().Y
+	if math.Abs(out.TargetCenter().Y-(y0+y1)/2) > 0.001 {
+		t.Errorf("target y=%f; expected half way between %f and %f", out.TargetCenter().Y, y0, y1)
+	}
+	if ip.Stats().Interpolated != 1 {
+		t.Errorf("Stats()=%+v; expected 1 interpolated frame", ip.Stats())
+	}
+
+	// the third state is late: extrapolate from the second
+	out = ip.Render(2*stepMS + stepMS/2)
+	if !(out.TargetCenter().Y > y1) {
+		t.Errorf("target y=%f; expected extrapolation past %f", out.TargetCenter().Y, y1)
+	}
+	if ip.Stats().Extrapolated != 1 {
+		t.Errorf("Stats()=%+v; expected 1 extrapolated frame", ip.Stats())
+	}
+
+	// extrapolation is limited
+	limited := ip.Render(100 * stepMS)
+	expected := states[1].Extrapolate(float64(DefaultMaxExtrapolateMS) / game.DefaultTimeStepMS)
+	if limited.TargetCenter() != expected.TargetCenter() {
+		t.Errorf("target=%s; expected extrapolation limited to %s", limited.TargetCenter(), expected.TargetCenter())
+	}
+
+	// old and duplicate states are ignored
+	ip.Add(3*stepMS, states[0])
+	ip.Add(3*stepMS, states[1])
+	ip.Add(3*stepMS, states[2])
+	if len(ip.states) != 2 {
+		t.Errorf("buffered %d states; expected 2", len(ip.states))
+	}
+}
+
+func TestInterpolatorBounded(t *testing.T) {
+	// the client buffers states even when it does not interpolate, and never calls Render
+	for _, delayMS := range []float64{0, 100} {
+		l := must(NewLoop(game.DefaultConfig(), 1))
+		l.Client.Interpolation.DelayMS = delayMS
+		l.AdvanceTo(10000 * game.DefaultTimeStepMS)
+		limitMS := delayMS + DefaultMaxExtrapolateMS
+		maxStates := int(limitMS/game.DefaultTimeStepMS) + 2
+		if n := len(l.Client.Interpolation.states); n == 0 || n > maxStates {
+			t.Errorf("delay=%f: buffered %d states; expected at most %d", delayMS, n, maxStates)
+		}
+	}
+}
+
+func TestInterpolateBullets(t *testing.T) {
+	g := game.MustNew(game.DefaultConfig())
+	g.ProcessInput(0, game.Input{Fire: true})
+	from := g.Clone()
+	g.SimulateTimeStep()
+	g.SimulateTimeStep()
+
+	out := game.Interpolate(from, g, 0.5)
+	if len(out.Bullets()) != 1 {
+		t.Fatalf("bullets=%v; expected 1 bullet", out.Bullets())
+	}
+	b := out.Bullets()[0]
+	expectedX := (from.Bullets()[0].X + g.Bullets()[0].X) / 2
+	if math.Abs(b.X-expectedX) > 0.001 {
+		t.Errorf("bullet x=%f; expected %f", b.X, expectedX)
+	}
+	if len(from.Bullets()) != 1 || from.Bullets()[0].X == b.X {
+		t.Error("Interpolate must not modify from")
+	}
+}
@@ -0,0 +1,371 @@
+package netcode
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/evanj/netgamesim/game"
+	"github.com/evanj/netgamesim/netsim"
+)
+
+// LockstepMessage is sent between lockstep peers. It contains all the sender's inputs that the
+// receiver has not acknowledged, so the next message replaces a lost one.
+type LockstepMessage struct {
+	// StartTick is the tick of Inputs[0].
+	StartTick int
+	Inputs    []game.Input
+	// AckTick is the first tick of the receiver's input that the sender does not have.
+	AckTick int
+	// Checksum is the game.Checksum of the sender's game at ChecksumTick.
+	ChecksumTick int
+	Checksum     uint64
+}
+
+// Size returns the encoded size of m in bytes.
+func (m LockstepMessage) Size() int {
+	// start tick, ack tick, checksum tick, and the 8 byte checksum
+	size := 5 * seqSize
+	for _, input := range m.Inputs {
+		data, err := input.MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		size += len(data)
+	}
+	return size
+}
+
+// LockstepStats counts what a lockstep peer simulated and waited for.
+type LockstepStats struct {
+	// Ticks is the number of simulated time steps.
+	Ticks int
+	// StallTicks is the number of time steps when the game did not advance, because the peer
+	// was waiting for the remote peer's input.
+	StallTicks int
+	// MaxStallMS is the longest time the peer waited for input.
+	MaxStallMS float64
+	// TotalInputDelayMS is the sum of the times between reading a local input and simulating
+	// it, for the InputDelays inputs.
+	TotalInputDelayMS float64
+	MaxInputDelayMS   float64
+	InputDelays       int
+	// ChecksumMismatches is the number of ticks where the peers' games were different.
+	ChecksumMismatches int
+	// FirstMismatchTick is the first tick where the games were different, or 0 if they never
+	// were.
+	FirstMismatchTick int
+}
+
+// lockstepInput is a local input and the time it was read.
+type lockstepInput struct {
+	input  game.Input
+	readMS float64
+}
+
+// LockstepPeer is one side of a deterministic lockstep game. Both peers run the whole
+// simulation and only exchange inputs: a peer simulates a time step once it has the inputs of
+// both players for it. Each player controls their own tank.
+type LockstepPeer struct {
+	player     game.PlayerID
+	inputDelay int
+	game       *game.Game
+	hitStats   HitStats
+
+	// the input for the next tick; Fire is kept until the input is read
+	input game.Input
+	// local inputs by tick, until they are simulated and acknowledged
+	local map[int]lockstepInput
+	// the next tick to read local input for
+	nextLocalTick int
+	// remote inputs by tick, until they are simulated
+	remote map[int]game.Input
+	// the first tick of remote input that has not been received
+	nextRemoteTick int
+	// the first tick of local input that the remote peer has not acknowledged
+	remoteAckTick int
+
+	checksums       checksumHistory
+	remoteChecksums checksumHistory
+	// time the peer started waiting for input, or negative if it is not waiting
+	stallStartMS float64
+	stats        LockstepStats
+}
+
+// NewLockstepPeer returns a peer for player with a game that follows the rules in cfg, where
+// local input is simulated inputDelay ticks after it is read. Inputs for the first inputDelay
+// ticks are empty. It returns an error if cfg or inputDelay is not valid.
+func NewLockstepPeer(cfg game.Config, player game.PlayerID, inputDelay int) (*LockstepPeer, error) {
+	g, err := newPeerGame(cfg, inputDelay)
+	if err != nil {
+		return nil, err
+	}
+	return &LockstepPeer{player, inputDelay, g, HitStats{},
+		game.Input{}, map[int]lockstepInput{}, inputDelay, map[int]game.Input{}, inputDelay,
+		inputDelay, newChecksumHistory(), newChecksumHistory(), -1, LockstepStats{}}, nil
+}
+
+// Game returns the peer's game.
+func (p *LockstepPeer) Game() *game.Game {
+	return p.game
+}
+
+// Stats returns the counts of simulated ticks, stalls and checksum mismatches.
+func (p *LockstepPeer) Stats() LockstepStats {
+	return p.stats
+}
+
+// HitStats returns the counts of shots and hits.
+func (p *LockstepPeer) HitStats() HitStats {
+	return p.hitStats
+}
+
+// SetInput sets the local player's input for the next tick. A fire is kept until the next tick
+// reads the input.
+func (p *LockstepPeer) SetInput(i game.Input) {
+	p.input.TankDir = i.TankDir
+	p.input.Fire = p.input.Fire || i.Fire
+}
+
+// Receive processes a message from the remote peer.
+func (p *LockstepPeer) Receive(m LockstepMessage) {
+	for i, input := range m.Inputs {
+		tick := m.StartTick + i
+		if tick >= p.nextRemoteTick {
+			p.remote[tick] = input
+		}
+	}
+	for {
+		if _, ok := p.remote[p.nextRemoteTick]; !ok {
+			break
+		}
+		p.nextRemoteTick++
+	}
+
+	if m.AckTick > p.remoteAckTick {
+		p.remoteAckTick = m.AckTick
+		for tick := range p.local {
+			if tick < p.remoteAckTick && tick < p.game.Ticks() {
+				delete(p.local, tick)
+			}
+		}
+	}
+
+	if m.ChecksumTick > 0 {
+		p.remoteChecksums.record(m.ChecksumTick, m.Checksum)
+		p.compareChecksum(m.ChecksumTick)
+	}
+}
+
+// compareChecksum compares the local and remote checksums for tick, if both are known.
+func (p *LockstepPeer) compareChecksum(tick int) {
+	local, ok := p.checksums.lookup(tick)
+	if !ok {
+		return
+	}
+	remote, ok := p.remoteChecksums.lookup(tick)
+	if !ok || local == remote {
+		return
+	}
+	p.stats.ChecksumMismatches++
+	if p.stats.FirstMismatchTick == 0 {
+		log.Printf("lockstep desync: player %d's game for tick %d is different from the remote peer",
+			p.player, tick)
+		p.stats.FirstMismatchTick = tick
+	}
+}
+
+// Tick is called every time step at nowMS. It reads the local input for the time step
+// inputDelay after the next one, then simulates the next time step if the peer has the inputs
+// of both players for it. Otherwise the game stalls: it does not advance until the remote input
+// arrives. It returns the message to send to the remote peer.
+func (p *LockstepPeer) Tick(nowMS float64) LockstepMessage {
+	tick := p.game.Ticks()
+	// read before simulating, so with an input delay of 0 the input is simulated in this tick.
+	// The input is sent while stalled, so the peers do not wait for each other.
+	if p.nextLocalTick == tick+p.inputDelay {
+		p.readInput(nowMS)
+	}
+	local, ok := p.localInput(tick)
+	if !ok {
+		panic("BUG: local input must be read before it is simulated")
+	}
+	remote, ok := p.remoteInput(tick)
+	if !ok {
+		p.stats.StallTicks++
+		if p.stallStartMS < 0 {
+			p.stallStartMS = nowMS
+		}
+		if stallMS := nowMS - p.stallStartMS; stallMS > p.stats.MaxStallMS {
+			p.stats.MaxStallMS = stallMS
+		}
+		return p.message()
+	}
+	p.stallStartMS = -1
+
+	p.hitStats.Shots += processPeerInputs(p.game, p.player, local.input, remote)
+	p.hitStats.Hits += p.game.SimulateTimeStep()
+	p.checksums.record(p.game.Ticks(), p.game.Checksum())
+	p.compareChecksum(p.game.Ticks())
+	delete(p.remote, tick)
+	if tick < p.remoteAckTick {
+		delete(p.local, tick)
+	}
+
+	p.stats.Ticks++
+	if tick >= p.inputDelay {
+		delay := nowMS - local.readMS
+		p.stats.TotalInputDelayMS += delay
+		p.stats.InputDelays++
+		if delay > p.stats.MaxInputDelayMS {
+			p.stats.MaxInputDelayMS = delay
+		}
+	}
+	return p.message()
+}
+
+// readInput reads the local input for the time step inputDelay after the next one.
+func (p *LockstepPeer) readInput(nowMS float64) {
+	// lag compensation does not apply: both peers simulate the same target
+	input := p.input
+	input.ViewTick = 0
+	p.local[p.nextLocalTick] = lockstepInput{input, nowMS}
+	p.nextLocalTick++
+	p.input.Fire = false
+}
+
+// newPeerGame returns a new game with the players of both peers, or an error if cfg or
+// inputDelay is not valid.
+func newPeerGame(cfg game.Config, inputDelay int) (*game.Game, error) {
+	if inputDelay < 0 {
+		return nil, fmt.Errorf("netcode: invalid input delay %d: must be at least 0", inputDelay)
+	}
+	g, err := game.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	g.AddPlayer()
+	return g, nil
+}
+
+// processPeerInputs applies the inputs of the local player and the remote player to g, in the
+// same order on both peers. It returns the number of shots.
+func processPeerInputs(g *game.Game, player game.PlayerID, local game.Input,
+	remote game.Input) int {
+
+	inputs := []game.Input{local, remote}
+	if player != 0 {
+		inputs[0], inputs[1] = remote, local
+	}
+	shots := 0
+	for i, input := range inputs {
+		g.ProcessInput(game.PlayerID(i), input)
+		if input.Fire {
+			shots++
+		}
+	}
+	return shots
+}
+
+// localInput returns the local input for tick.
+func (p *LockstepPeer) localInput(tick int) (lockstepInput, bool) {
+	if tick < p.inputDelay {
+		return lockstepInput{}, true
+	}
+	input, ok := p.local[tick]
+	return input, ok
+}
+
+// remoteInput returns the remote input for tick, or false if it has not arrived.
+func (p *LockstepPeer) remoteInput(tick int) (game.Input, bool) {
+	if tick < p.inputDelay {
+		return game.Input{}, true
+	}
+	input, ok := p.remote[tick]
+	return input, ok
+}
+
+// message returns the message with the local inputs the remote peer does not have.
+func (p *LockstepPeer) message() LockstepMessage {
+	inputs := make([]game.Input, 0, p.nextLocalTick-p.remoteAckTick)
+	for tick := p.remoteAckTick; tick < p.nextLocalTick; tick++ {
+		inputs = append(inputs, p.local[tick].input)
+	}
+	// tick 0 is never compared: both peers start with newPeerGame()
+	ticks := p.game.Ticks()
+	sum, ok := p.checksums.lookup(ticks)
+	if !ok {
+		ticks = 0
+	}
+	return LockstepMessage{p.remoteAckTick, inputs, p.nextRemoteTick, ticks, sum}
+}
+
+// LockstepNetwork is a simulated network between two lockstep peers.
+type LockstepNetwork = netsim.Network[LockstepMessage, LockstepMessage]
+
+// LockstepLoop runs two lockstep peers connected by a simulated network, with a clock
+// controlled by the caller. The client peer is player 0, which gets the input, and the
+// server peer is player 1, which has no input.
+type LockstepLoop struct {
+	Client *LockstepPeer
+	Server *LockstepPeer
+	// Net.ClientToServer carries the client's messages; Net.ServerToClient the server's.
+	Net *LockstepNetwork
+
+	// time of the last time step
+	tickMS float64
+}
+
+// NewLockstepLoop returns a loop with new peers that follow the rules in cfg, where local input
+// is simulated inputDelay ticks after it is read, and a network with no latency. It returns an
+// error if cfg or inputDelay is not valid.
+func NewLockstepLoop(cfg game.Config, seed int64, inputDelay int) (*LockstepLoop, error) {
+	client, err := NewLockstepPeer(cfg, 0, inputDelay)
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewLockstepPeer(cfg, 1, inputDelay)
+	if err != nil {
+		return nil, err
+	}
+	net := netsim.NewNetwork[LockstepMessage, LockstepMessage](seed)
+	net.ClientToServer.Size = LockstepMessage.Size
+	net.ServerToClient.Size = LockstepMessage.Size
+	return &LockstepLoop{client, server, net, 0}, nil
+}
+
+// AdvanceTo runs the time steps before nowMS. Each time step delivers messages to each peer,
+// then the peer simulates what it can and sends its inputs.
+func (l *LockstepLoop) AdvanceTo(nowMS float64) {
+	stepMS := float64(l.Client.Game().Config().TimeStepMS)
+	for tickMS := l.tickMS + stepMS; tickMS < nowMS; tickMS += stepMS {
+		for {
+			m, ok := l.Net.ServerIncoming(tickMS)
+			if !ok {
+				break
+			}
+			l.Server.Receive(m)
+		}
+		l.Net.SendToClient(tickMS, l.Server.Tick(tickMS))
+
+		for {
+			m, ok := l.Net.ClientIncoming(tickMS)
+			if !ok {
+				break
+			}
+			l.Client.Receive(m)
+		}
+		l.Net.SendToServer(tickMS, l.Client.Tick(tickMS))
+
+		l.tickMS = tickMS
+	}
+}
+
+// SendInput sets the client's input for its next tick.
+func (l *LockstepLoop) SendInput(nowMS float64, i game.Input) {
+	l.Client.SetInput(i)
+}
+
+// Render returns the client's game to display at nowMS.
+func (l *LockstepLoop) Render(nowMS float64) *game.Game {
+	return l.Client.Game()
+}
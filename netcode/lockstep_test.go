@@ -0,0 +1,118 @@
+package netcode
+
+import (
+	"testing"
+
+	"github.com/evanj/netgamesim/game"
+)
+
+// peerLoop is a loop of two peers: LockstepLoop or RollbackLoop.
+type peerLoop interface {
+	AdvanceTo(nowMS float64)
+	SendInput(nowMS float64, i game.Input)
+}
+
+// runLockstep runs time steps fromTick to toTick, where the client changes direction every 10
+// time steps.
+func runLockstep(l peerLoop, fromTick int, toTick int) {
+	dirs := []game.Direction{game.DirRight, game.DirDown, game.DirNone, game.DirLeft, game.DirUp}
+	for tick := fromTick; tick <= toTick; tick++ {
+		nowMS := float64(tick * game.DefaultTimeStepMS)
+		l.SendInput(nowMS, game.Input{TankDir: dirs[(tick/10)%len(dirs)], Fire: tick%7 == 0})
+		l.AdvanceTo(nowMS + game.DefaultTimeStepMS/2)
+	}
+}
+
+func TestLockstep(t *testing.T) {
+	l := must(NewLockstepLoop(game.DefaultConfig(), 1, 2))
+	runLockstep(l, 1, 500)
+	client := l.Client.Stats()
+	server := l.Server.Stats()
+	if client.Ticks < 495 || client.StallTicks != 0 || client.ChecksumMismatches != 0 {
+		t.Errorf("client stats=%+v; expected no stalls or mismatches", client)
+	}
+	if server.StallTicks != 0 || server.ChecksumMismatches != 0 {
+		t.Errorf("server stats=%+v; expected no stalls or mismatches", server)
+	}
+	expectedDelayMS := float64(2 * game.DefaultTimeStepMS)
+	if client.InputDelays == 0 || client.MaxInputDelayMS != expectedDelayMS {
+		t.Errorf("client stats=%+v; expected input delay %f ms", client, expectedDelayMS)
+	}
+	if l.Client.HitStats().Shots == 0 || l.Client.HitStats() != l.Server.HitStats() {
+		t.Errorf("client hits=%+v server hits=%+v; expected the same shots",
+			l.Client.HitStats(), l.Server.HitStats())
+	}
+
+	// both peers simulate the same game
+	if l.Client.Game().Ticks() != l.Server.Game().Ticks() ||
+		l.Client.Game().Checksum() != l.Server.Game().Checksum() {
+		t.Errorf("client tick=%d server tick=%d; expected the same game",
+			l.Client.Game().Ticks(), l.Server.Game().Ticks())
+	}
+	if l.Client.Game().TankCenter(0) == game.MustNew(game.DefaultConfig()).TankCenter(0) {
+		t.Error("the client's input did not move the tank")
+	}
+}
+
+func TestLockstepLatency(t *testing.T) {
+	// the input delay hides less latency than the round trip: the peers stall
+	l := must(NewLockstepLoop(game.DefaultConfig(), 1, 2))
+	l.Net.SetLatencyMS(100)
+	runLockstep(l, 1, 500)
+	stalled := l.Client.Stats()
+	if stalled.StallTicks == 0 || stalled.MaxStallMS <= 0 {
+		t.Errorf("input delay 2: stats=%+v; expected stalls", stalled)
+	}
+
+	// a larger input delay hides the latency
+	l = must(NewLockstepLoop(game.DefaultConfig(), 1, 8))
+	l.Net.SetLatencyMS(100)
+	runLockstep(l, 1, 500)
+	hidden := l.Client.Stats()
+	if hidden.StallTicks != 0 || !(hidden.Ticks > stalled.Ticks) {
+		t.Errorf("input delay 8: stats=%+v; expected no stalls", hidden)
+	}
+
+	// lost messages are replaced by the next one
+	l = must(NewLockstepLoop(game.DefaultConfig(), 1, 8))
+	l.Net.SetLatencyMS(50)
+	l.Net.ClientToServer.Config.LossProbability = 0.2
+	l.Net.ServerToClient.Config.LossProbability = 0.2
+	runLockstep(l, 1, 500)
+	if l.Client.Stats().ChecksumMismatches != 0 || l.Server.Stats().ChecksumMismatches != 0 {
+		t.Errorf("loss: client stats=%+v server stats=%+v; expected no mismatches",
+			l.Client.Stats(), l.Server.Stats())
+	}
+	if len(l.Client.local) > 100 || len(l.Server.remote) > 100 {
+		t.Errorf("len(local)=%d len(remote)=%d; inputs must be deleted after they are used",
+			len(l.Client.local), len(l.Server.remote))
+	}
+}
+
+func TestLockstepMismatch(t *testing.T) {
+	l := must(NewLockstepLoop(game.DefaultConfig(), 1, 2))
+	runLockstep(l, 1, 100)
+	// simulate a bug: the server's game is different
+	l.Server.Game().ProcessInput(0, game.Input{TankDir: game.DirLeft, Fire: true})
+	runLockstep(l, 101, 200)
+	stats := l.Client.Stats()
+	if stats.ChecksumMismatches == 0 || !(100 < stats.FirstMismatchTick && stats.FirstMismatchTick <= 103) {
+		t.Errorf("stats=%+v; expected mismatches starting at tick 101", stats)
+	}
+}
+
+func TestLockstepNoInputDelay(t *testing.T) {
+	// the input is simulated when it is read: the peers stall until the remote input arrives
+	l := must(NewLockstepLoop(game.DefaultConfig(), 1, 0))
+	runLockstep(l, 1, 500)
+	client := l.Client.Stats()
+	if client.Ticks < 250 || client.ChecksumMismatches != 0 {
+		t.Errorf("client stats=%+v; expected ticks and no mismatches", client)
+	}
+	if l.Server.Stats().ChecksumMismatches != 0 {
+		t.Errorf("server stats=%+v; expected no mismatches", l.Server.Stats())
+	}
+	if l.Client.HitStats().Shots == 0 {
+		t.Errorf("client hits=%+v; expected shots", l.Client.HitStats())
+	}
+}
@@ -0,0 +1,143 @@
+package netcode
+
+import (
+	"github.com/evanj/netgamesim/game"
+	"github.com/evanj/netgamesim/netsim"
+)
+
+// encoded sizes of the message headers: the messages are not serialized, but this is how big
+// they would be
+const seqSize = 4
+
+// Size returns the encoded size of m in bytes.
+func (m InputMessage) Size() int {
+	data, err := m.Input.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	// sequence number and acknowledged ticks
+	return 2*seqSize + len(data)
+}
+
+// Size returns the encoded size of m in bytes.
+func (m StateMessage) Size() int {
+	// sequence number, time steps, ticks, delta flag, baseline ticks and the 8 byte checksum
+	return 4*seqSize + 1 + 8 + len(m.State)
+}
+
+// Network is a simulated network between a client and a server.
+type Network = netsim.Network[InputMessage, StateMessage]
+
+// Loop runs a server and its clients, each connected by its own simulated network, with a clock
+// controlled by the caller. The browser demo uses the browser's clock and the headless runner
+// uses a virtual clock, so they simulate the same way.
+type Loop struct {
+	// Client and Net are the client of player 0 and its network.
+	Client *Client
+	Server *Server
+	Net    *Network
+	// Clients and Nets are all the clients and their networks, indexed by game.PlayerID.
+	// Clients[0] is Client and Nets[0] is Net.
+	Clients []*Client
+	Nets    []*Network
+
+	// time of the last simulated time step
+	serverMS float64
+}
+
+// NewLoop returns a loop with a new server and one client that follow the rules in cfg, and a
+// network with no latency, where seed makes the network's random choices reproducible. It
+// returns an error if cfg is not valid.
+func NewLoop(cfg game.Config, seed int64) (*Loop, error) {
+	client, err := NewClient(cfg, 0)
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	net := newNetwork(seed)
+	return &Loop{client, server, net, []*Client{client}, []*Network{net}, 0}, nil
+}
+
+func newNetwork(seed int64) *Network {
+	net := netsim.NewNetwork[InputMessage, StateMessage](seed)
+	net.ClientToServer.Size = InputMessage.Size
+	net.ServerToClient.Size = StateMessage.Size
+	return net
+}
+
+// AddClient adds a player to the server, and a client for it with the same settings as Client.
+// Its network has no latency, and seed makes its random choices reproducible. It returns the
+// new client.
+func (l *Loop) AddClient(seed int64) *Client {
+	client, err := NewClient(l.Server.Game().Config(), l.Server.AddPlayer())
+	if err != nil {
+		panic("BUG: the server's rules must be valid: " + err.Error())
+	}
+	client.Predict = l.Client.Predict
+	client.Reconcile = l.Client.Reconcile
+	client.LagCompensation = l.Client.LagCompensation
+	client.Interpolation.DelayMS = l.Client.Interpolation.DelayMS
+	client.Interpolation.MaxExtrapolateMS = l.Client.Interpolation.MaxExtrapolateMS
+	l.Clients = append(l.Clients, client)
+	l.Nets = append(l.Nets, newNetwork(seed))
+	return client
+}
+
+// AdvanceTo simulates the time steps before nowMS. Each time step delivers the inputs to the
+// server, simulates the server and sends the states, then simulates the clients and delivers the
+// states to the clients.
+func (l *Loop) AdvanceTo(nowMS float64) {
+	// simulate the network advancing by single ticks; we can't show anything more often than 60
+	// fps anyway, so latency is "quantized" to frames anaway
+	stepMS := float64(l.Server.Game().Config().TimeStepMS)
+	for serverTime := l.serverMS + stepMS; serverTime < nowMS; serverTime += stepMS {
+		// process server network input
+		for player, net := range l.Nets {
+			for {
+				input, ok := net.ServerIncoming(serverTime)
+				if !ok {
+					break
+				}
+				l.Server.ReceiveInput(game.PlayerID(player), input)
+			}
+		}
+
+		// simulate the time on the server; send the updated state to the clients
+		states := l.Server.SimulateTimeStep()
+		for player, net := range l.Nets {
+			net.SendToClient(serverTime, states[player])
+		}
+
+		// simulate the same time step on the clients, then process client network messages
+		for player, client := range l.Clients {
+			client.SimulateTimeStep()
+			for {
+				state, ok := l.Nets[player].ClientIncoming(serverTime)
+				if !ok {
+					break
+				}
+				client.ReceiveState(serverTime, state)
+			}
+		}
+
+		l.serverMS = serverTime
+	}
+}
+
+// SendInput applies the input on Client and sends it to the server at nowMS.
+func (l *Loop) SendInput(nowMS float64, i game.Input) {
+	l.SendPlayerInput(nowMS, 0, i)
+}
+
+// SendPlayerInput applies the input on player's client and sends it to the server at nowMS.
+func (l *Loop) SendPlayerInput(nowMS float64, player game.PlayerID, i game.Input) {
+	l.Nets[player].SendToServer(nowMS, l.Clients[player].ApplyInput(i))
+}
+
+// Render returns the client's game to display at nowMS.
+func (l *Loop) Render(nowMS float64) *game.Game {
+	return l.Client.Render(nowMS)
+}
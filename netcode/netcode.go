@@ -0,0 +1,459 @@
+// Package netcode implements the client and server sides of the network game model. It does not
+// depend on the browser or the network, so it can be used by the WASM demo, command line tools
+// and tests.
+package netcode
+
+import (
+	"errors"
+	"log"
+	"math"
+
+	"github.com/evanj/netgamesim/game"
+)
+
+// corrections smaller than this many pixels are rounding errors
+const correctionEpsilon = 0.01
+
+// number of states the server and client keep as delta compression baselines: every baseline
+// the codec accepts, and no more.
+const maxBaselines = game.MaxDeltaTicks + 1
+
+var errMissingBaseline = errors.New("netcode: delta baseline is not available")
+
+// InputMessage is sent from the client to the server.
+type InputMessage struct {
+	// Seq is the input's sequence number. It starts at 1 and increases by 1 for each input.
+	Seq uint32
+	// AckTicks is the tick of the last state the client received, which the server can use as
+	// the baseline for delta compression.
+	AckTicks int
+	Input    game.Input
+}
+
+// StateMessage is sent from the server to the client.
+type StateMessage struct {
+	// LastSeq is the sequence number of the last input processed by the server.
+	LastSeq uint32
+	// TimeSteps is the number of time steps the server simulated after processing LastSeq.
+	TimeSteps int
+	// Ticks is the tick of the encoded state.
+	Ticks int
+	// Delta is true if State is encoded with game.MarshalDelta against the state at
+	// BaselineTicks. Otherwise it is encoded with game.MarshalBinary.
+	Delta         bool
+	BaselineTicks int
+	State         []byte
+	// Checksum is the state's game.Checksum, so the client can verify it decoded the same state.
+	Checksum uint64
+}
+
+// SnapshotStats counts the states sent by the server.
+type SnapshotStats struct {
+	Full  int
+	Delta int
+	// Bytes is the total size of the encoded states.
+	Bytes int
+	// FullBytes is the total size the states would have been without delta compression.
+	FullBytes int
+}
+
+// HitStats counts the bullets fired by the clients and the bullets that hit the target, on the
+// server.
+type HitStats struct {
+	Shots int
+	Hits  int
+}
+
+// serverClient is the server's state for one client.
+type serverClient struct {
+	lastSeq   uint32
+	timeSteps int
+	// the client's last acknowledged tick
+	ackTicks int
+}
+
+// Server is the authoritative game state, shared by the clients of all players.
+type Server struct {
+	// LagCompensation tests bullet hits against the target where the client saw it when it
+	// fired, instead of where the target is on the server.
+	LagCompensation bool
+	// DeltaCompression encodes states as the changes since the last state the client has
+	// acknowledged. If the client has not acknowledged a recent state, it sends the full state.
+	DeltaCompression bool
+
+	game *game.Game
+	// indexed by game.PlayerID
+	clients []serverClient
+	// sent states, indexed by Ticks() % maxBaselines
+	baselines []*game.Game
+	stats     SnapshotStats
+	hitStats  HitStats
+}
+
+// NewServer returns a server with a new game with one player that follows the rules in cfg,
+// without lag compensation or delta compression. It returns an error if cfg is not valid.
+func NewServer(cfg game.Config) (*Server, error) {
+	g, err := game.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{false, false, g, make([]serverClient, 1),
+		make([]*game.Game, maxBaselines), SnapshotStats{}, HitStats{}}, nil
+}
+
+// AddPlayer adds a player to the game for a new client and returns its ID.
+func (s *Server) AddPlayer() game.PlayerID {
+	player := s.game.AddPlayer()
+	s.clients = append(s.clients, serverClient{})
+	return player
+}
+
+// Game returns the server's game.
+func (s *Server) Game() *game.Game {
+	return s.game
+}
+
+// ReceiveInput processes input from player's client. Inputs that are older than the last
+// processed input are duplicated or reordered by the network and are ignored.
+func (s *Server) ReceiveInput(player game.PlayerID, m InputMessage) {
+	client := &s.clients[player]
+	if m.Seq <= client.lastSeq {
+		return
+	}
+	if !s.LagCompensation {
+		m.Input.ViewTick = 0
+	}
+	s.game.ProcessInput(player, m.Input)
+	if m.Input.Fire {
+		s.hitStats.Shots++
+	}
+	client.lastSeq = m.Seq
+	client.timeSteps = 0
+	if m.AckTicks > client.ackTicks {
+		client.ackTicks = m.AckTicks
+	}
+}
+
+// SnapshotStats returns the counts of states sent by the server.
+func (s *Server) SnapshotStats() SnapshotStats {
+	return s.stats
+}
+
+// HitStats returns the counts of shots and hits.
+func (s *Server) HitStats() HitStats {
+	return s.hitStats
+}
+
+// SimulateTimeStep advances the server's game by one time step, and returns the states to send
+// to the clients, indexed by game.PlayerID.
+func (s *Server) SimulateTimeStep() []StateMessage {
+	s.hitStats.Hits += s.game.SimulateTimeStep()
+
+	ticks := s.game.Ticks()
+	full, err := s.game.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	checksum := s.game.Checksum()
+	out := make([]StateMessage, len(s.clients))
+	for i := range s.clients {
+		client := &s.clients[i]
+		client.timeSteps++
+		m := StateMessage{client.lastSeq, client.timeSteps, ticks, false, 0, full, checksum}
+
+		// each client has acknowledged a different baseline
+		baseline := s.baselines[client.ackTicks%maxBaselines]
+		if s.DeltaCompression && baseline != nil && baseline.Ticks() == client.ackTicks &&
+			ticks-client.ackTicks <= game.MaxDeltaTicks {
+
+			delta, err := s.game.MarshalDelta(baseline)
+			if err != nil {
+				panic(err)
+			}
+			if len(delta) < len(full) {
+				m.Delta = true
+				m.BaselineTicks = client.ackTicks
+				m.State = delta
+			}
+		}
+
+		if m.Delta {
+			s.stats.Delta++
+		} else {
+			s.stats.Full++
+		}
+		s.stats.Bytes += len(m.State)
+		s.stats.FullBytes += len(full)
+		out[i] = m
+	}
+	s.baselines[ticks%maxBaselines] = s.game.Clone()
+	return out
+}
+
+// CorrectionStats counts how often and how far server reconciliation moved the predicted tank.
+type CorrectionStats struct {
+	// Reconciles is the number of states from the server that were reconciled.
+	Reconciles int
+	// Corrections is the number of reconciles that moved the predicted tank.
+	Corrections int
+	// TotalDistance is the sum of the distances the tank was moved, in pixels.
+	TotalDistance float64
+	// MaxDistance is the largest distance the tank was moved, in pixels.
+	MaxDistance float64
+}
+
+// pendingInput is an input that the server has not processed yet, or the last input it has
+// processed.
+type pendingInput struct {
+	InputMessage
+	// number of time steps the client simulated after applying this input
+	timeSteps int
+}
+
+// Client is the view of the game of one player's client.
+type Client struct {
+	// Predict enables client-side prediction: the client applies its own input to its copy of the
+	// game right away, instead of waiting for the server to send back the result. If false, the
+	// client is "dumb" and only shows the last state from the server.
+	Predict bool
+	// Reconcile corrects the prediction when a state arrives from the server: the client starts
+	// from the server's state, and replays the inputs the server has not processed yet. If
+	// false, the client keeps its predicted tank, which can drift from the server.
+	Reconcile bool
+	// Interpolation renders the objects that are not controlled by the player in the past, by
+	// blending between states from the server.
+	Interpolation Interpolator
+	// LagCompensation must be the same as the server's: the predicted bullets are tested against
+	// the target like the server tests them.
+	LagCompensation bool
+
+	player  game.PlayerID
+	game    *game.Game
+	lastSeq uint32
+	// Ticks() of the last state received from the server
+	serverTicks int
+	// Ticks() of the last game returned by Render, for lag compensation
+	viewTicks int
+	// received states, indexed by Ticks() % maxBaselines
+	baselines []*game.Game
+	// inputs applied to the predicted game, starting with the last input processed by the server
+	pending []pendingInput
+	stats   CorrectionStats
+	// checksums of the predicted game
+	predicted checksumHistory
+	// true once the server has processed an input: before that, the predicted game's ticks are
+	// not the server's ticks
+	aligned     bool
+	desyncStats DesyncStats
+}
+
+// NewClient returns a dumb client for player with a new game that follows the rules in cfg,
+// which must be the same as the server's. The game has the players up to player until the first
+// state from the server arrives. It returns an error if cfg is not valid.
+func NewClient(cfg game.Config, player game.PlayerID) (*Client, error) {
+	g, err := game.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for g.Players() <= int(player) {
+		g.AddPlayer()
+	}
+	return &Client{false, false, Interpolator{}, false, player, g, 0, 0, 0,
+		make([]*game.Game, maxBaselines), nil, CorrectionStats{}, newChecksumHistory(), false,
+		DesyncStats{}}, nil
+}
+
+// Player returns the client's player.
+func (c *Client) Player() game.PlayerID {
+	return c.player
+}
+
+// Game returns the client's current game. With prediction, this is the predicted game.
+func (c *Client) Game() *game.Game {
+	return c.game
+}
+
+// Render returns the game to display at nowMS. If interpolation is enabled, the objects that
+// are not controlled by the player come from the interpolator, and the tank comes from Game.
+func (c *Client) Render(nowMS float64) *game.Game {
+	out := c.game
+	if c.Interpolation.DelayMS > 0 {
+		remote := c.Interpolation.Render(nowMS)
+		if remote != nil {
+			remote.CopyTank(c.game, c.player)
+			out = remote
+		}
+	}
+	c.viewTicks = out.Ticks()
+	return out
+}
+
+// CorrectionStats returns the counts of prediction corrections.
+func (c *Client) CorrectionStats() CorrectionStats {
+	return c.stats
+}
+
+// DesyncStats returns the counts of differences between the predicted game and the server.
+func (c *Client) DesyncStats() DesyncStats {
+	return c.desyncStats
+}
+
+// ApplyInput is called when the client sends input to the server. It returns the message to
+// send, which includes the tick of the last rendered game for lag compensation.
+func (c *Client) ApplyInput(i game.Input) InputMessage {
+	if i.Fire {
+		i.ViewTick = c.viewTicks
+	}
+	c.lastSeq++
+	m := InputMessage{c.lastSeq, c.serverTicks, i}
+	if c.Predict {
+		c.game.ProcessInput(c.player, c.predictedInput(i))
+		c.pending = append(c.pending, pendingInput{m, 0})
+	}
+	return m
+}
+
+// predictedInput returns i as the server will process it.
+func (c *Client) predictedInput(i game.Input) game.Input {
+	if !c.LagCompensation {
+		i.ViewTick = 0
+	}
+	return i
+}
+
+// SimulateTimeStep advances the client's game by one time step, if it is predicting.
+func (c *Client) SimulateTimeStep() {
+	if c.Predict {
+		c.game.SimulateTimeStep()
+		c.predicted.record(c.game.Ticks(), c.game.Checksum())
+		if len(c.pending) > 0 {
+			c.pending[len(c.pending)-1].timeSteps++
+		}
+	}
+}
+
+// ReceiveState replaces the client's game with state from the server that arrived at nowMS.
+// When predicting, the client keeps its own predicted tank, and corrects it if Reconcile is
+// set. When reconciling, each replayed tick is compared to the predicted game for the same
+// tick, to find where the prediction diverged from the server. States that are older than the
+// last state are duplicated or reordered by the network and are ignored.
+func (c *Client) ReceiveState(nowMS float64, m StateMessage) {
+	if m.Ticks <= c.serverTicks {
+		return
+	}
+	received, err := c.decodeState(m)
+	if err != nil {
+		log.Printf("failed to decode state for tick %d: %s", m.Ticks, err.Error())
+		return
+	}
+	if received.Checksum() != m.Checksum {
+		log.Printf("decoded state for tick %d does not match the server's checksum", m.Ticks)
+		c.desyncStats.ChecksumErrors++
+		return
+	}
+	if received.Players() <= int(c.player) {
+		log.Printf("state for tick %d does not have player %d", m.Ticks, c.player)
+		return
+	}
+	c.serverTicks = m.Ticks
+	c.baselines[m.Ticks%maxBaselines] = received
+	c.Interpolation.Add(nowMS, received)
+
+	// drop inputs that the server has processed, except the last one
+	processed := 0
+	for processed < len(c.pending) && c.pending[processed].Seq < m.LastSeq {
+		processed++
+	}
+	c.pending = c.pending[processed:]
+
+	// the client can modify its game: do not modify the baseline
+	state := received.Clone()
+	if !c.Predict {
+		c.pending = nil
+		c.game = state
+		return
+	}
+	if !c.Reconcile {
+		state.CopyTank(c.game, c.player)
+		c.game = state
+		return
+	}
+
+	// the client's game runs ahead of the server by the uplink latency, which it only knows once
+	// the server has processed an input: the ticks predicted before that are not comparable
+	if !c.aligned && m.LastSeq > 0 {
+		c.aligned = true
+		c.predicted = newChecksumHistory()
+	}
+
+	// rewind to the server's state and replay the inputs it has not processed
+	predicted := c.game.TankCenter(c.player)
+	desync := false
+	for _, p := range c.pending {
+		timeSteps := p.timeSteps
+		if p.Seq == m.LastSeq {
+			// the server already processed this input: only simulate the time steps that the
+			// client simulated after it, but the server has not
+			timeSteps -= m.TimeSteps
+		} else {
+			state.ProcessInput(c.player, c.predictedInput(p.Input))
+		}
+		for i := 0; i < timeSteps; i++ {
+			state.SimulateTimeStep()
+			desync = c.checkPrediction(state) || desync
+		}
+	}
+	c.game = state
+	if desync {
+		c.desyncStats.Desyncs++
+	}
+
+	c.stats.Reconciles++
+	corrected := c.game.TankCenter(c.player)
+	distance := corrected.Distance(predicted)
+	if distance > correctionEpsilon {
+		c.stats.Corrections++
+		c.stats.TotalDistance += distance
+		c.stats.MaxDistance = math.Max(c.stats.MaxDistance, distance)
+	}
+}
+
+// checkPrediction compares state to the predicted game for the same tick, then records state as
+// the new prediction. It returns true if they are different.
+func (c *Client) checkPrediction(state *game.Game) bool {
+	tick := state.Ticks()
+	sum := state.Checksum()
+	predictedSum, ok := c.predicted.lookup(tick)
+	c.predicted.record(tick, sum)
+	if !ok || !c.aligned {
+		return false
+	}
+	c.desyncStats.Checked++
+	if predictedSum == sum {
+		return false
+	}
+	if c.desyncStats.FirstTick == 0 {
+		log.Printf("desync: the predicted game for tick %d is different from the server", tick)
+	}
+	if c.desyncStats.FirstTick == 0 || tick < c.desyncStats.FirstTick {
+		c.desyncStats.FirstTick = tick
+	}
+	return true
+}
+
+// decodeState returns the game encoded in m.
+func (c *Client) decodeState(m StateMessage) (*game.Game, error) {
+	// decoding keeps the rules of the game it decodes into
+	state := game.MustNew(c.game.Config())
+	if !m.Delta {
+		err := state.UnmarshalBinary(m.State)
+		return state, err
+	}
+
+	baseline := c.baselines[m.BaselineTicks%maxBaselines]
+	if baseline == nil || baseline.Ticks() != m.BaselineTicks {
+		return nil, errMissingBaseline
+	}
+	err := state.UnmarshalDelta(baseline, m.State)
+	return state, err
+}
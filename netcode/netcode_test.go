@@ -0,0 +1,318 @@
+package netcode
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/evanj/netgamesim/game"
+)
+
+func TestClientPredict(t *testing.T) {
+	server := game.MustNew(game.DefaultConfig())
+	start := server.TankCenter(0)
+	right := game.Input{TankDir: game.DirRight}
+
+	dumb := must(NewClient(game.DefaultConfig(), 0))
+	dumb.ApplyInput(right)
+	dumb.SimulateTimeStep()
+	if dumb.Game().TankCenter(0) != start {
+		t.Errorf("dumb client moved the tank to %s before the server", dumb.Game().TankCenter(0))
+	}
+
+	predict := must(NewClient(game.DefaultConfig(), 0))
+	predict.Predict = true
+	predict.ApplyInput(right)
+	predict.SimulateTimeStep()
+	predicted := predict.Game().TankCenter(0)
+	if !(predicted.X > start.X) {
+		t.Errorf("predicting client did not move the tank right: %s", predicted)
+	}
+
+	// the server has not seen the input yet: the client keeps its predicted tank
+	server.SimulateTimeStep()
+	state, err := server.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	predict.ReceiveState(0, StateMessage{0, 1, server.Ticks(), false, 0, state, server.Checksum()})
+	if predict.Game().TankCenter(0) != predicted {
+		t.Errorf("tank=%s; expected the predicted position %s", predict.Game().TankCenter(0), predicted)
+	}
+	if predict.Game().TargetCenter() != server.TargetCenter() {
+		t.Errorf("target=%s; expected the server's target %s",
+			predict.Game().TargetCenter(), server.TargetCenter())
+	}
+}
+
+// must returns v, and panics if err is not nil.
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func newTestLoop(latencyMS float64) *Loop {
+	l := must(NewLoop(game.DefaultConfig(), 1))
+	l.Client.Predict = true
+	l.Client.Reconcile = true
+	l.Net.SetLatencyMS(latencyMS)
+	return l
+}
+
+// step runs time step tick, where the client sends input.
+func step(l *Loop, tick int, input game.Input) {
+	nowMS := float64(tick * game.DefaultTimeStepMS)
+	l.SendInput(nowMS, input)
+	l.AdvanceTo(nowMS + game.DefaultTimeStepMS/2)
+}
+
+// run runs ticks time steps. The client changes direction every 10 time steps.
+func run(l *Loop, ticks int) {
+	dirs := []game.Direction{game.DirRight, game.DirDown, game.DirNone, game.DirLeft, game.DirUp}
+	for tick := 1; tick <= ticks; tick++ {
+		step(l, tick, game.Input{TankDir: dirs[(tick/10)%len(dirs)], Fire: tick%7 == 0})
+	}
+}
+
+func TestClientReconcile(t *testing.T) {
+	// with a constant latency, the prediction is always correct
+	l := newTestLoop(100)
+	run(l, 500)
+	stats := l.Client.CorrectionStats()
+	if stats.Reconciles == 0 || stats.Corrections != 0 {
+		t.Errorf("constant latency: stats=%+v; expected no corrections", stats)
+	}
+
+	// jitter changes when the server processes inputs: the client must correct its prediction
+	l = newTestLoop(100)
+	l.Net.ClientToServer.Config.JitterMS = 50
+	l.Net.ClientToServer.Config.LossProbability = 0.1
+	l.Net.ClientToServer.Config.ReorderProbability = 0.1
+	run(l, 500)
+	stats = l.Client.CorrectionStats()
+	if stats.Corrections == 0 || stats.MaxDistance <= 0 {
+		t.Errorf("jitter: stats=%+v; expected corrections", stats)
+	}
+
+	// once the client stops moving, it converges to the server
+	for tick := 501; tick <= 600; tick++ {
+		step(l, tick, game.Input{})
+	}
+	if l.Client.Game().TankCenter(0) != l.Server.Game().TankCenter(0) {
+		t.Errorf("client tank=%s; server tank=%s; expected the client to converge",
+			l.Client.Game().TankCenter(0), l.Server.Game().TankCenter(0))
+	}
+}
+
+func TestDeltaCompression(t *testing.T) {
+	for _, lossProbability := range []float64{0, 0.2} {
+		l := newTestLoop(100)
+		l.Server.DeltaCompression = true
+		l.Net.ClientToServer.Config.LossProbability = lossProbability
+		l.Net.ServerToClient.Config.LossProbability = lossProbability
+		run(l, 500)
+
+		stats := l.Server.SnapshotStats()
+		if stats.Delta == 0 || stats.Full == 0 || stats.Bytes >= stats.FullBytes {
+			t.Errorf("loss=%f: stats=%+v; expected smaller delta states", lossProbability, stats)
+		}
+
+		// the client decodes the same state that the server sent
+		ticks := l.Client.serverTicks
+		if ticks < 500-10 {
+			t.Errorf("loss=%f: client serverTicks=%d; expected recent states", lossProbability, ticks)
+		}
+		sent, err := l.Server.baselines[ticks%maxBaselines].MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, err := l.Client.baselines[ticks%maxBaselines].MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, sent) {
+			t.Errorf("loss=%f: client state=%v; expected server state %v", lossProbability, received, sent)
+		}
+	}
+}
+
+func TestDesync(t *testing.T) {
+	dirs := []game.Direction{game.DirRight, game.DirDown, game.DirNone, game.DirLeft, game.DirUp}
+	move := func(l *Loop, fromTick int, toTick int) {
+		for tick := fromTick; tick <= toTick; tick++ {
+			step(l, tick, game.Input{TankDir: dirs[(tick/10)%len(dirs)], Fire: tick%20 == 0})
+		}
+	}
+
+	// with a constant latency, the prediction is the same as the server, including before the
+	// server processes the first input, when the predicted game moves ahead of the server
+	for _, lagCompensation := range []bool{false, true} {
+		for _, latencyMS := range []float64{48, 100} {
+			l := newTestLoop(latencyMS)
+			l.Client.LagCompensation = lagCompensation
+			l.Server.LagCompensation = lagCompensation
+			move(l, 1, 500)
+			stats := l.Client.DesyncStats()
+			if stats.Checked == 0 || stats.Desyncs != 0 || stats.FirstTick != 0 {
+				t.Errorf("lag compensation=%t latency=%f: stats=%+v; expected no desyncs",
+					lagCompensation, latencyMS, stats)
+			}
+		}
+	}
+
+	// jitter changes when the server processes inputs: the prediction is wrong
+	l := newTestLoop(100)
+	move(l, 1, 50)
+	l.Net.ClientToServer.Config.JitterMS = 50
+	move(l, 51, 500)
+	stats := l.Client.DesyncStats()
+	if stats.Desyncs == 0 || stats.FirstTick <= 50 {
+		t.Errorf("jitter: stats=%+v; expected desyncs after the jitter started", stats)
+	}
+
+	// a state that does not match its checksum is dropped
+	client := must(NewClient(game.DefaultConfig(), 0))
+	m := must(NewServer(game.DefaultConfig())).SimulateTimeStep()[0]
+	m.Checksum++
+	client.ReceiveState(0, m)
+	stats = client.DesyncStats()
+	if stats.ChecksumErrors != 1 || client.serverTicks != 0 {
+		t.Errorf("stats=%+v serverTicks=%d; expected the state to be dropped",
+			stats, client.serverTicks)
+	}
+}
+
+func TestMultiplePlayers(t *testing.T) {
+	server := must(NewServer(game.DefaultConfig()))
+	server.DeltaCompression = true
+	clients := []*Client{must(NewClient(game.DefaultConfig(), 0)),
+		must(NewClient(game.DefaultConfig(), server.AddPlayer()))}
+	for _, c := range clients {
+		c.Predict = true
+		c.Reconcile = true
+	}
+	inputs := []game.Input{{TankDir: game.DirDown}, {TankDir: game.DirRight, Fire: true}}
+
+	// player 1 only acknowledges every other state, so the server sends different deltas
+	for tick := 1; tick <= 20; tick++ {
+		for i, c := range clients {
+			server.ReceiveInput(c.Player(), c.ApplyInput(inputs[i]))
+			c.SimulateTimeStep()
+		}
+		states := server.SimulateTimeStep()
+		if len(states) != len(clients) {
+			t.Fatalf("len(states)=%d; expected one per client", len(states))
+		}
+		clients[0].ReceiveState(0, states[0])
+		if tick%2 == 0 {
+			clients[1].ReceiveState(0, states[1])
+		}
+	}
+
+	start := game.MustNew(game.DefaultConfig())
+	start.AddPlayer()
+	for i, c := range clients {
+		g := c.Game()
+		if g.Players() != 2 || c.DesyncStats().ChecksumErrors != 0 {
+			t.Fatalf("client %d: players=%d stats=%+v; expected 2 players and no errors",
+				i, g.Players(), c.DesyncStats())
+		}
+		// each client sees both tanks move
+		if !(g.TankCenter(0).Y > start.TankCenter(0).Y && g.TankCenter(1).X > start.TankCenter(1).X) {
+			t.Errorf("client %d: tanks=%s,%s; expected player 0 to move down and player 1 right",
+				i, g.TankCenter(0), g.TankCenter(1))
+		}
+	}
+	if server.HitStats().Shots != 20 {
+		t.Errorf("hits=%+v; expected only player 1's shots", server.HitStats())
+	}
+	if server.SnapshotStats().Delta == 0 {
+		t.Errorf("snapshot stats=%+v; expected deltas", server.SnapshotStats())
+	}
+}
+
+func TestLoopClients(t *testing.T) {
+	l := newTestLoop(0)
+	l.Client.Interpolation.MaxExtrapolateMS = 100
+	slow := l.AddClient(2)
+	l.Nets[slow.Player()].SetLatencyMS(200)
+	if !slow.Predict || !slow.Reconcile || slow.Interpolation.MaxExtrapolateMS != 100 ||
+		len(l.Clients) != 2 {
+		t.Fatalf("added client predict=%t reconcile=%t max extrapolate=%f; expected the settings "+
+			"of the first client", slow.Predict, slow.Reconcile, slow.Interpolation.MaxExtrapolateMS)
+	}
+
+	// the slow player moves right: it sees its own tank right away, but the others see it late
+	for tick := 1; tick <= 50; tick++ {
+		nowMS := float64(tick * game.DefaultTimeStepMS)
+		l.SendInput(nowMS, game.Input{})
+		l.SendPlayerInput(nowMS, slow.Player(), game.Input{TankDir: game.DirRight})
+		l.AdvanceTo(nowMS + game.DefaultTimeStepMS/2)
+	}
+	own := slow.Game().TankCenter(slow.Player())
+	seen := l.Client.Game().TankCenter(slow.Player())
+	if !(own.X > seen.X && seen.X > game.MustNew(game.DefaultConfig()).TankCenter(0).X) {
+		t.Errorf("slow player's tank=%s; first client sees %s; expected it behind but moving", own, seen)
+	}
+
+	// once the slow player stops, everyone agrees
+	for tick := 51; tick <= 100; tick++ {
+		nowMS := float64(tick * game.DefaultTimeStepMS)
+		l.SendInput(nowMS, game.Input{})
+		l.SendPlayerInput(nowMS, slow.Player(), game.Input{})
+		l.AdvanceTo(nowMS + game.DefaultTimeStepMS/2)
+	}
+	expected := l.Server.Game().TankCenter(slow.Player())
+	for _, c := range l.Clients {
+		if c.Game().TankCenter(slow.Player()) != expected {
+			t.Errorf("player %d sees tank %s; expected the server's %s",
+				c.Player(), c.Game().TankCenter(slow.Player()), expected)
+		}
+	}
+}
+
+func TestLoopConfig(t *testing.T) {
+	// a 20 Hz server with slower tanks: the client decodes states with the same rules
+	cfg := game.DefaultConfig()
+	cfg.TimeStepMS = 50
+	cfg.TankMovePerSecond = 100
+	l := must(NewLoop(cfg, 1))
+	l.Client.Predict = true
+	l.Client.Reconcile = true
+	l.Net.SetLatencyMS(100)
+	for tick := 1; tick <= 100; tick++ {
+		nowMS := float64(tick * cfg.TimeStepMS)
+		l.SendInput(nowMS, game.Input{TankDir: game.DirDown})
+		l.AdvanceTo(nowMS + float64(cfg.TimeStepMS)/2)
+	}
+	if l.Server.Game().Ticks() != 100 || l.Client.Game().Config().TimeStepMS != cfg.TimeStepMS {
+		t.Errorf("server ticks=%d client config=%+v; expected 100 ticks with the loop's rules",
+			l.Server.Game().Ticks(), l.Client.Game().Config())
+	}
+	if stats := l.Client.CorrectionStats(); stats.Reconciles == 0 || stats.Corrections != 0 {
+		t.Errorf("stats=%+v; expected the prediction with the same rules to be correct", stats)
+	}
+
+	// invalid rules and settings are returned as errors
+	invalid := game.DefaultConfig()
+	invalid.TimeStepMS = 0
+	for name, err := range map[string]error{
+		"loop":           second(NewLoop(invalid, 1)),
+		"lockstep":       second(NewLockstepLoop(invalid, 1, 2)),
+		"rollback":       second(NewRollbackLoop(invalid, 1, 2, 8)),
+		"inputDelay":     second(NewLockstepLoop(game.DefaultConfig(), 1, -1)),
+		"rollbackDelay":  second(NewRollbackLoop(game.DefaultConfig(), 1, -1, 8)),
+		"maxRollback":    second(NewRollbackLoop(game.DefaultConfig(), 1, 2, MaxRollbackTicks+1)),
+		"negativeWindow": second(NewRollbackLoop(game.DefaultConfig(), 1, 2, -1)),
+	} {
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// second returns err, for testing functions that must fail.
+func second[T any](v T, err error) error {
+	return err
+}
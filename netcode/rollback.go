@@ -0,0 +1,394 @@
+package netcode
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/evanj/netgamesim/game"
+	"github.com/evanj/netgamesim/netsim"
+)
+
+// MaxRollbackTicks is the largest maximum rollback window: checksums are only kept for
+// maxBaselines ticks.
+const MaxRollbackTicks = maxBaselines - 1
+
+// RollbackStats counts what a rollback peer predicted and re-simulated.
+type RollbackStats struct {
+	// Ticks is the number of time steps the game advanced, not counting re-simulated ones.
+	Ticks int
+	// PredictedTicks is the number of time steps simulated with a predicted remote input.
+	PredictedTicks int
+	// Rollbacks is the number of times a predicted input was wrong and the game was restored
+	// from a snapshot.
+	Rollbacks int
+	// RollbackTicks is the total number of re-simulated time steps, and MaxRollbackTicks the
+	// most for one rollback.
+	RollbackTicks    int
+	MaxRollbackTicks int
+	// StallTicks is the number of time steps when the game did not advance, because it was
+	// already the maximum rollback window ahead of the remote peer's input.
+	StallTicks int
+	MaxStallMS float64
+	// ChecksumMismatches is the number of ticks where the peers' confirmed games were different.
+	ChecksumMismatches int
+	// FirstMismatchTick is the first tick where the games were different, or 0 if they never
+	// were.
+	FirstMismatchTick int
+}
+
+// rollbackTick is what a rollback peer saves when it simulates a tick, until the tick is
+// confirmed.
+type rollbackTick struct {
+	// the game before the tick
+	before *game.Game
+	// the remote input used for the tick: predicted or received
+	remote   game.Input
+	shots    int
+	hits     int
+	checksum uint64
+}
+
+// RollbackPeer is one side of a rollback game. Like LockstepPeer, both peers run the whole
+// simulation and only exchange inputs. Instead of waiting for the remote input, a peer predicts
+// it by repeating the last one it received, without the shot. When the real input arrives and
+// is different, the peer restores the game from the snapshot before that tick and re-simulates
+// up to the present. Each player controls their own tank.
+type RollbackPeer struct {
+	player      game.PlayerID
+	inputDelay  int
+	maxRollback int
+	game        *game.Game
+	hitStats    HitStats
+
+	// the input for the next tick; Fire is kept until the input is read
+	input game.Input
+	// local inputs by tick, until they are confirmed and acknowledged
+	local map[int]game.Input
+	// the next tick to read local input for
+	nextLocalTick int
+	// remote inputs by tick, until they are confirmed
+	remote map[int]game.Input
+	// the first tick of remote input that has not been received
+	nextRemoteTick int
+	// the last remote input received, which predicts the following ones
+	lastRemote game.Input
+	// the first tick of local input that the remote peer has not acknowledged
+	remoteAckTick int
+
+	// the simulated ticks that are not confirmed; indexed by tick % len(ticks)
+	ticks []rollbackTick
+	// the first tick that was not simulated with the inputs of both players
+	confirmedTick int
+	// the first tick to re-simulate, or negative if the predictions were correct
+	rollbackTick int
+
+	checksums       checksumHistory
+	remoteChecksums checksumHistory
+	// time the peer started waiting for input, or negative if it is not waiting
+	stallStartMS float64
+	stats        RollbackStats
+}
+
+// NewRollbackPeer returns a peer for player with a game that follows the rules in cfg, where
+// local input is simulated inputDelay ticks after it is read, and the game runs at most
+// maxRollback ticks ahead of the remote input. With maxRollback 0 it is the same as lockstep.
+// It returns an error if cfg, inputDelay or maxRollback is not valid.
+func NewRollbackPeer(cfg game.Config, player game.PlayerID, inputDelay int, maxRollback int) (
+	*RollbackPeer, error) {
+
+	if !(0 <= maxRollback && maxRollback <= MaxRollbackTicks) {
+		return nil, fmt.Errorf("netcode: invalid max rollback %d: must be between 0 and %d",
+			maxRollback, MaxRollbackTicks)
+	}
+	g, err := newPeerGame(cfg, inputDelay)
+	if err != nil {
+		return nil, err
+	}
+	return &RollbackPeer{player, inputDelay, maxRollback, g, HitStats{},
+		game.Input{}, map[int]game.Input{}, inputDelay, map[int]game.Input{}, inputDelay,
+		game.Input{}, inputDelay, make([]rollbackTick, maxRollback+1), 0, -1,
+		newChecksumHistory(), newChecksumHistory(), -1, RollbackStats{}}, nil
+}
+
+// Game returns the peer's game, which includes the predicted ticks.
+func (p *RollbackPeer) Game() *game.Game {
+	return p.game
+}
+
+// Stats returns the counts of predicted ticks, rollbacks, stalls and checksum mismatches.
+func (p *RollbackPeer) Stats() RollbackStats {
+	return p.stats
+}
+
+// HitStats returns the counts of shots and hits in confirmed ticks.
+func (p *RollbackPeer) HitStats() HitStats {
+	return p.hitStats
+}
+
+// SetInput sets the local player's input for the next tick. A fire is kept until the next tick
+// reads the input.
+func (p *RollbackPeer) SetInput(i game.Input) {
+	p.input.TankDir = i.TankDir
+	p.input.Fire = p.input.Fire || i.Fire
+}
+
+// Receive processes a message from the remote peer. If an input is different from the one
+// predicted for its tick, the next Tick rolls back to it.
+func (p *RollbackPeer) Receive(m LockstepMessage) {
+	for i, input := range m.Inputs {
+		tick := m.StartTick + i
+		if tick < p.nextRemoteTick {
+			continue
+		}
+		p.remote[tick] = input
+		if tick < p.game.Ticks() && p.ticks[tick%len(p.ticks)].remote != input &&
+			(p.rollbackTick < 0 || tick < p.rollbackTick) {
+			p.rollbackTick = tick
+		}
+	}
+	for {
+		input, ok := p.remote[p.nextRemoteTick]
+		if !ok {
+			break
+		}
+		p.lastRemote = input
+		p.lastRemote.Fire = false
+		p.nextRemoteTick++
+	}
+
+	if m.AckTick > p.remoteAckTick {
+		p.remoteAckTick = m.AckTick
+		p.deleteLocal()
+	}
+
+	if m.ChecksumTick > 0 {
+		p.remoteChecksums.record(m.ChecksumTick, m.Checksum)
+		p.compareChecksum(m.ChecksumTick)
+	}
+}
+
+// deleteLocal deletes the local inputs that are confirmed and acknowledged.
+func (p *RollbackPeer) deleteLocal() {
+	for tick := range p.local {
+		if tick < p.remoteAckTick && tick < p.confirmedTick {
+			delete(p.local, tick)
+		}
+	}
+}
+
+// compareChecksum compares the local and remote checksums for tick, if both are known.
+func (p *RollbackPeer) compareChecksum(tick int) {
+	local, ok := p.checksums.lookup(tick)
+	if !ok {
+		return
+	}
+	remote, ok := p.remoteChecksums.lookup(tick)
+	if !ok || local == remote {
+		return
+	}
+	p.stats.ChecksumMismatches++
+	if p.stats.FirstMismatchTick == 0 {
+		log.Printf("rollback desync: player %d's game for tick %d is different from the remote peer",
+			p.player, tick)
+		p.stats.FirstMismatchTick = tick
+	}
+}
+
+// Tick is called every time step at nowMS. It first rolls back and re-simulates if a received
+// input was mispredicted. Then it reads the local input for the time step inputDelay after the
+// next one, and simulates the next time step, predicting the remote input if it has not
+// arrived, unless that would run more than the maximum rollback window ahead. It returns the
+// message to send to the remote peer.
+func (p *RollbackPeer) Tick(nowMS float64) LockstepMessage {
+	if p.rollbackTick >= 0 {
+		end := p.game.Ticks()
+		p.game = p.ticks[p.rollbackTick%len(p.ticks)].before
+		for tick := p.rollbackTick; tick < end; tick++ {
+			p.simulate(tick)
+		}
+		depth := end - p.rollbackTick
+		p.stats.Rollbacks++
+		p.stats.RollbackTicks += depth
+		if depth > p.stats.MaxRollbackTicks {
+			p.stats.MaxRollbackTicks = depth
+		}
+		p.rollbackTick = -1
+	}
+	p.confirm()
+
+	tick := p.game.Ticks()
+	// like LockstepPeer, read before simulating and while stalled
+	if p.nextLocalTick == tick+p.inputDelay {
+		p.readInput()
+	}
+	if _, ok := p.remoteInput(tick); !ok && tick-p.confirmedTick >= p.maxRollback {
+		p.stats.StallTicks++
+		if p.stallStartMS < 0 {
+			p.stallStartMS = nowMS
+		}
+		if stallMS := nowMS - p.stallStartMS; stallMS > p.stats.MaxStallMS {
+			p.stats.MaxStallMS = stallMS
+		}
+		return p.message()
+	}
+	p.stallStartMS = -1
+	p.simulate(tick)
+	p.stats.Ticks++
+	p.confirm()
+	return p.message()
+}
+
+// readInput reads the local input for the time step inputDelay after the next one.
+func (p *RollbackPeer) readInput() {
+	// lag compensation does not apply: both peers simulate the same target
+	input := p.input
+	input.ViewTick = 0
+	p.local[p.nextLocalTick] = input
+	p.nextLocalTick++
+	p.input.Fire = false
+}
+
+// simulate simulates tick, saving the game before it.
+func (p *RollbackPeer) simulate(tick int) {
+	if tick != p.game.Ticks() {
+		panic("BUG: simulated tick must be the game's next tick")
+	}
+	saved := &p.ticks[tick%len(p.ticks)]
+	saved.before = p.game.Clone()
+
+	remote, ok := p.remoteInput(tick)
+	if !ok {
+		remote = p.lastRemote
+		p.stats.PredictedTicks++
+	}
+	saved.remote = remote
+
+	saved.shots = processPeerInputs(p.game, p.player, p.localInput(tick), remote)
+	saved.hits = p.game.SimulateTimeStep()
+	saved.checksum = p.game.Checksum()
+}
+
+// confirm counts the stats and records the checksums of the simulated ticks that used the real
+// inputs of both players, and deletes their inputs.
+func (p *RollbackPeer) confirm() {
+	for p.confirmedTick < p.game.Ticks() && p.confirmedTick < p.nextRemoteTick {
+		tick := p.confirmedTick
+		saved := &p.ticks[tick%len(p.ticks)]
+		p.hitStats.Shots += saved.shots
+		p.hitStats.Hits += saved.hits
+		p.checksums.record(tick+1, saved.checksum)
+		p.compareChecksum(tick + 1)
+		saved.before = nil
+		delete(p.remote, tick)
+		p.confirmedTick++
+	}
+	p.deleteLocal()
+}
+
+// localInput returns the local input for tick.
+func (p *RollbackPeer) localInput(tick int) game.Input {
+	if tick < p.inputDelay {
+		return game.Input{}
+	}
+	input, ok := p.local[tick]
+	if !ok {
+		panic("BUG: local input must be read before it is simulated")
+	}
+	return input
+}
+
+// remoteInput returns the remote input for tick, or false if it has not arrived.
+func (p *RollbackPeer) remoteInput(tick int) (game.Input, bool) {
+	if tick < p.inputDelay {
+		return game.Input{}, true
+	}
+	input, ok := p.remote[tick]
+	return input, ok
+}
+
+// message returns the message with the local inputs the remote peer does not have, and the
+// checksum of the last confirmed tick.
+func (p *RollbackPeer) message() LockstepMessage {
+	inputs := make([]game.Input, 0, p.nextLocalTick-p.remoteAckTick)
+	for tick := p.remoteAckTick; tick < p.nextLocalTick; tick++ {
+		inputs = append(inputs, p.local[tick])
+	}
+	// tick 0 is never compared: both peers start with newPeerGame()
+	ticks := p.confirmedTick
+	sum, ok := p.checksums.lookup(ticks)
+	if !ok {
+		ticks = 0
+	}
+	return LockstepMessage{p.remoteAckTick, inputs, p.nextRemoteTick, ticks, sum}
+}
+
+// RollbackLoop runs two rollback peers connected by a simulated network, with a clock
+// controlled by the caller. The client peer is player 0, which gets the input, and the
+// server peer is player 1, which has no input, so only the server peer mispredicts.
+type RollbackLoop struct {
+	Client *RollbackPeer
+	Server *RollbackPeer
+	// Net.ClientToServer carries the client's messages; Net.ServerToClient the server's.
+	Net *LockstepNetwork
+
+	// time of the last time step
+	tickMS float64
+}
+
+// NewRollbackLoop returns a loop with new peers that follow the rules in cfg, where local input
+// is simulated inputDelay ticks after it is read and the game runs at most maxRollback ticks
+// ahead of the remote input, and a network with no latency. It returns an error if cfg,
+// inputDelay or maxRollback is not valid.
+func NewRollbackLoop(cfg game.Config, seed int64, inputDelay int, maxRollback int) (
+	*RollbackLoop, error) {
+
+	client, err := NewRollbackPeer(cfg, 0, inputDelay, maxRollback)
+	if err != nil {
+		return nil, err
+	}
+	server, err := NewRollbackPeer(cfg, 1, inputDelay, maxRollback)
+	if err != nil {
+		return nil, err
+	}
+	net := netsim.NewNetwork[LockstepMessage, LockstepMessage](seed)
+	net.ClientToServer.Size = LockstepMessage.Size
+	net.ServerToClient.Size = LockstepMessage.Size
+	return &RollbackLoop{client, server, net, 0}, nil
+}
+
+// AdvanceTo runs the time steps before nowMS. Each time step delivers messages to each peer,
+// then the peer simulates and sends its inputs.
+func (l *RollbackLoop) AdvanceTo(nowMS float64) {
+	stepMS := float64(l.Client.Game().Config().TimeStepMS)
+	for tickMS := l.tickMS + stepMS; tickMS < nowMS; tickMS += stepMS {
+		for {
+			m, ok := l.Net.ServerIncoming(tickMS)
+			if !ok {
+				break
+			}
+			l.Server.Receive(m)
+		}
+		l.Net.SendToClient(tickMS, l.Server.Tick(tickMS))
+
+		for {
+			m, ok := l.Net.ClientIncoming(tickMS)
+			if !ok {
+				break
+			}
+			l.Client.Receive(m)
+		}
+		l.Net.SendToServer(tickMS, l.Client.Tick(tickMS))
+
+		l.tickMS = tickMS
+	}
+}
+
+// SendInput sets the client's input for its next tick.
+func (l *RollbackLoop) SendInput(nowMS float64, i game.Input) {
+	l.Client.SetInput(i)
+}
+
+// Render returns the client's game to display at nowMS.
+func (l *RollbackLoop) Render(nowMS float64) *game.Game {
+	return l.Client.Game()
+}
@@ -0,0 +1,124 @@
+package netcode
+
+import (
+	"testing"
+
+	"github.com/evanj/netgamesim/game"
+)
+
+// runIdle runs time steps fromTick to toTick without new input.
+func runIdle(l peerLoop, fromTick int, toTick int) {
+	for tick := fromTick; tick <= toTick; tick++ {
+		l.AdvanceTo(float64(tick*game.DefaultTimeStepMS) + game.DefaultTimeStepMS/2)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	const maxRollback = 16
+	l := must(NewRollbackLoop(game.DefaultConfig(), 1, 2, maxRollback))
+	l.Net.SetLatencyMS(100)
+	runLockstep(l, 1, 500)
+	runIdle(l, 501, 700)
+
+	client := l.Client.Stats()
+	server := l.Server.Stats()
+	// only the client has input: its predictions are correct
+	if client.StallTicks != 0 || client.Rollbacks != 0 || client.ChecksumMismatches != 0 {
+		t.Errorf("client stats=%+v; expected no stalls, rollbacks or mismatches", client)
+	}
+	if server.StallTicks != 0 || server.ChecksumMismatches != 0 {
+		t.Errorf("server stats=%+v; expected no stalls or mismatches", server)
+	}
+	if server.PredictedTicks == 0 || server.Rollbacks == 0 || server.RollbackTicks < server.Rollbacks ||
+		!(0 < server.MaxRollbackTicks && server.MaxRollbackTicks <= maxRollback) {
+		t.Errorf("server stats=%+v; expected rollbacks up to %d ticks", server, maxRollback)
+	}
+	if l.Client.HitStats().Shots == 0 || l.Client.HitStats() != l.Server.HitStats() {
+		t.Errorf("client hits=%+v server hits=%+v; expected the same shots",
+			l.Client.HitStats(), l.Server.HitStats())
+	}
+
+	// both peers end up with the same game as lockstep without latency
+	lockstep := must(NewLockstepLoop(game.DefaultConfig(), 1, 2))
+	runLockstep(lockstep, 1, 500)
+	runIdle(lockstep, 501, 700)
+	expected := lockstep.Client.Game()
+	for _, peer := range []*RollbackPeer{l.Client, l.Server} {
+		g := peer.Game()
+		if g.Ticks() != expected.Ticks() || g.Checksum() != expected.Checksum() {
+			t.Errorf("player %d: tick=%d tank=%s; expected tick=%d tank=%s",
+				peer.player, g.Ticks(), g.TankCenter(0), expected.Ticks(), expected.TankCenter(0))
+		}
+	}
+	if len(l.Server.local) > maxRollback || len(l.Server.remote) > maxRollback {
+		t.Errorf("len(local)=%d len(remote)=%d; inputs must be deleted after they are confirmed",
+			len(l.Server.local), len(l.Server.remote))
+	}
+}
+
+func TestRollbackWindow(t *testing.T) {
+	// the round trip is longer than the window: the peers stall
+	const maxRollback = 4
+	l := must(NewRollbackLoop(game.DefaultConfig(), 1, 2, maxRollback))
+	l.Net.SetLatencyMS(200)
+	runLockstep(l, 1, 500)
+	for _, stats := range []RollbackStats{l.Client.Stats(), l.Server.Stats()} {
+		if stats.StallTicks == 0 || stats.MaxRollbackTicks > maxRollback {
+			t.Errorf("stats=%+v; expected stalls and rollbacks up to %d ticks", stats, maxRollback)
+		}
+	}
+
+	// without a window it is lockstep: it never predicts
+	l = must(NewRollbackLoop(game.DefaultConfig(), 1, 2, 0))
+	l.Net.SetLatencyMS(100)
+	runLockstep(l, 1, 500)
+	lockstep := must(NewLockstepLoop(game.DefaultConfig(), 1, 2))
+	lockstep.Net.SetLatencyMS(100)
+	runLockstep(lockstep, 1, 500)
+	stats := l.Server.Stats()
+	if stats.PredictedTicks != 0 || stats.Rollbacks != 0 ||
+		stats.StallTicks != lockstep.Server.Stats().StallTicks {
+		t.Errorf("stats=%+v lockstep=%+v; expected the same stalls and no predictions",
+			stats, lockstep.Server.Stats())
+	}
+
+	// lost messages are replaced by the next one
+	l = must(NewRollbackLoop(game.DefaultConfig(), 1, 2, 16))
+	l.Net.SetLatencyMS(50)
+	l.Net.ClientToServer.Config.LossProbability = 0.2
+	l.Net.ServerToClient.Config.LossProbability = 0.2
+	runLockstep(l, 1, 500)
+	if l.Client.Stats().ChecksumMismatches != 0 || l.Server.Stats().ChecksumMismatches != 0 {
+		t.Errorf("loss: client stats=%+v server stats=%+v; expected no mismatches",
+			l.Client.Stats(), l.Server.Stats())
+	}
+}
+
+func TestRollbackMismatch(t *testing.T) {
+	l := must(NewRollbackLoop(game.DefaultConfig(), 1, 2, 8))
+	runLockstep(l, 1, 100)
+	// simulate a bug: the server's game is different
+	l.Server.Game().ProcessInput(0, game.Input{TankDir: game.DirLeft, Fire: true})
+	runLockstep(l, 101, 200)
+	stats := l.Client.Stats()
+	if stats.ChecksumMismatches == 0 || !(100 < stats.FirstMismatchTick && stats.FirstMismatchTick <= 103) {
+		t.Errorf("stats=%+v; expected mismatches starting at tick 101", stats)
+	}
+}
+
+func TestRollbackNoInputDelay(t *testing.T) {
+	// the input is simulated when it is read: the remote peer always predicts it
+	l := must(NewRollbackLoop(game.DefaultConfig(), 1, 0, 8))
+	runLockstep(l, 1, 500)
+	client := l.Client.Stats()
+	server := l.Server.Stats()
+	if client.Ticks < 495 || client.StallTicks != 0 || client.ChecksumMismatches != 0 {
+		t.Errorf("client stats=%+v; expected no stalls or mismatches", client)
+	}
+	if server.StallTicks != 0 || server.Rollbacks == 0 || server.ChecksumMismatches != 0 {
+		t.Errorf("server stats=%+v; expected rollbacks and no stalls or mismatches", server)
+	}
+	if l.Client.HitStats().Shots == 0 {
+		t.Errorf("client hits=%+v; expected shots", l.Client.HitStats())
+	}
+}
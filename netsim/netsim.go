@@ -0,0 +1,295 @@
+// Package netsim simulates the network between a game client and server. Messages are queued
+// and delivered after a configurable one way latency, with optional jitter, loss, duplication,
+// reordering and a bandwidth limit. All randomness comes from a seeded random number generator so runs can be
+// reproduced. It does not depend on the browser, so it can be shared by the WASM demo, command
+// line tools and tests.
+package netsim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// DefaultReorderDelayMS is the extra delay of a reordered message when
+// LinkConfig.ReorderDelayMS is zero.
+const DefaultReorderDelayMS = 20
+
+// Distribution is the probability distribution of the random jitter added to each message.
+type Distribution int
+
+const (
+	// JitterUniform adds a delay uniformly distributed in [0, JitterMS].
+	JitterUniform = Distribution(iota)
+	// JitterNormal adds the absolute value of a normal distribution with standard deviation
+	// JitterMS. Most messages have a small delay, with a few larger outliers.
+	JitterNormal
+	// JitterExponential adds an exponentially distributed delay with mean JitterMS. This has a
+	// long tail of very late messages.
+	JitterExponential
+)
+
+func (d Distribution) String() string {
+	switch d {
+	case JitterUniform:
+		return "uniform"
+	case JitterNormal:
+		return "normal"
+	case JitterExponential:
+		return "exponential"
+	default:
+		return fmt.Sprintf("Distribution(%d)", int(d))
+	}
+}
+
+// LinkConfig describes the behavior of a one way link. The zero value is a perfect link that
+// delivers every message in order with no delay.
+type LinkConfig struct {
+	// LatencyMS is the minimum one way latency in milliseconds.
+	LatencyMS float64
+
+	// JitterMS is the scale of the random extra delay added to each message. Jitter does not
+	// reorder messages: a message is never delivered before one sent before it, unless it is
+	// reordered by ReorderProbability.
+	JitterMS float64
+	// Jitter is the distribution of the random extra delay.
+	Jitter Distribution
+
+	// LossProbability is the probability that a message is dropped.
+	LossProbability float64
+	// DuplicateProbability is the probability that a message is delivered twice.
+	DuplicateProbability float64
+	// ReorderProbability is the probability that a message is held back by ReorderDelayMS, so
+	// messages sent after it can overtake it.
+	ReorderProbability float64
+	// ReorderDelayMS is the extra delay of a reordered message. If it is zero,
+	// DefaultReorderDelayMS is used.
+	ReorderDelayMS float64
+
+	// BytesPerSecond limits the bandwidth of the link. Messages are sent one at a time; a message
+	// waits until all messages sent before it have been transmitted, then takes
+	// size/BytesPerSecond to transmit. Zero means unlimited bandwidth.
+	BytesPerSecond float64
+	// MTU is the largest message in bytes the link can carry. Zero means unlimited.
+	MTU int
+	// Fragment splits messages larger than MTU into MTU sized fragments. If any fragment is lost,
+	// the entire message is lost. If false, messages larger than MTU are dropped.
+	Fragment bool
+}
+
+// Stats counts what happened to messages on a link.
+type Stats struct {
+	Sent       int
+	Delivered  int
+	Lost       int
+	Duplicated int
+	Reordered  int
+	// Oversize is the number of messages dropped because they were larger than the MTU.
+	Oversize int
+	// Fragments is the number of fragments sent for messages larger than the MTU.
+	Fragments int
+
+	// Bytes is the total size of all sent messages.
+	Bytes int
+	// QueueMS is the total time messages waited for earlier messages to be transmitted.
+	QueueMS float64
+}
+
+// Link is a one way network link. The zero value is a perfect link with no latency, using a
+// random number generator with seed 0. Changes to Config only apply to messages sent after the
+// change.
+type Link[T any] struct {
+	Config LinkConfig
+	// Size returns the encoded size of a message in bytes, for the bandwidth limit and MTU. If it
+	// is nil, all messages have size zero.
+	Size func(T) int
+
+	rng *rand.Rand
+	// delivery time of the last message that was not reordered, to keep jitter in order
+	lastInOrderMS float64
+	// time when the link has finished transmitting all queued messages
+	busyUntilMS float64
+	// sorted by deliverMS
+	queue []message[T]
+	stats Stats
+}
+
+type message[T any] struct {
+	deliverMS float64
+	value     T
+}
+
+// Seed resets the link's random number generator.
+func (l *Link[T]) Seed(seed int64) {
+	l.rng = rand.New(rand.NewSource(seed))
+}
+
+func (l *Link[T]) random() *rand.Rand {
+	if l.rng == nil {
+		l.Seed(0)
+	}
+	return l.rng
+}
+
+// chance returns true with probability p. It only uses the random number generator if p is
+// not zero, so adding a feature with zero probability does not change the results of a seed.
+func (l *Link[T]) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	return l.random().Float64() < p
+}
+
+func (l *Link[T]) jitterMS() float64 {
+	if l.Config.JitterMS <= 0 {
+		return 0
+	}
+	switch l.Config.Jitter {
+	case JitterNormal:
+		return math.Abs(l.random().NormFloat64()) * l.Config.JitterMS
+	case JitterExponential:
+		return l.random().ExpFloat64() * l.Config.JitterMS
+	default:
+		return l.random().Float64() * l.Config.JitterMS
+	}
+}
+
+// Send queues msg for delivery. sentMS is the current time in milliseconds.
+func (l *Link[T]) Send(sentMS float64, msg T) {
+	l.stats.Sent++
+	size := 0
+	if l.Size != nil {
+		size = l.Size(msg)
+	}
+
+	fragments := 1
+	if l.Config.MTU > 0 && size > l.Config.MTU {
+		if !l.Config.Fragment {
+			l.stats.Oversize++
+			return
+		}
+		fragments = (size + l.Config.MTU - 1) / l.Config.MTU
+		l.stats.Fragments += fragments
+	}
+
+	// the message uses the link's bandwidth even if it is lost
+	l.stats.Bytes += size
+	transmittedMS := sentMS
+	if l.Config.BytesPerSecond > 0 {
+		startMS := math.Max(sentMS, l.busyUntilMS)
+		l.stats.QueueMS += startMS - sentMS
+		transmittedMS = startMS + float64(size)*1000/l.Config.BytesPerSecond
+		l.busyUntilMS = transmittedMS
+	}
+
+	for i := 0; i < fragments; i++ {
+		if l.chance(l.Config.LossProbability) {
+			l.stats.Lost++
+			return
+		}
+	}
+
+	copies := 1
+	if l.chance(l.Config.DuplicateProbability) {
+		l.stats.Duplicated++
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		l.schedule(transmittedMS+l.Config.LatencyMS+l.jitterMS(), msg)
+	}
+}
+
+// schedule queues msg to be delivered at deliverMS, applying reordering.
+func (l *Link[T]) schedule(deliverMS float64, msg T) {
+	if l.chance(l.Config.ReorderProbability) {
+		l.stats.Reordered++
+		delay := l.Config.ReorderDelayMS
+		if delay == 0 {
+			delay = DefaultReorderDelayMS
+		}
+		deliverMS += delay
+	} else {
+		// jitter alone does not reorder messages
+		deliverMS = math.Max(deliverMS, l.lastInOrderMS)
+		l.lastInOrderMS = deliverMS
+	}
+
+	// insert after all messages delivered at the same time or earlier
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].deliverMS > deliverMS {
+		i--
+	}
+	l.queue = append(l.queue, message[T]{})
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = message[T]{deliverMS, msg}
+}
+
+// Receive returns the next message that has arrived by nowMS. It returns false if no message
+// has arrived yet.
+func (l *Link[T]) Receive(nowMS float64) (T, bool) {
+	if len(l.queue) == 0 || l.queue[0].deliverMS > nowMS {
+		var zero T
+		return zero, false
+	}
+
+	out := l.queue[0].value
+	l.queue = l.queue[1:]
+	l.stats.Delivered++
+	return out, true
+}
+
+// Len returns the number of messages that are in flight.
+func (l *Link[T]) Len() int {
+	return len(l.queue)
+}
+
+// Stats returns the counts of what has happened to messages on this link.
+func (l *Link[T]) Stats() Stats {
+	return l.stats
+}
+
+// Network is a pair of links between a client and a server. C is the type of messages sent by
+// the client, and S is the type of messages sent by the server. Each direction is configured
+// independently, so the network can be asymmetric (e.g. a slow uplink on a mobile network).
+type Network[C any, S any] struct {
+	// ClientToServer is the uplink.
+	ClientToServer Link[C]
+	// ServerToClient is the downlink.
+	ServerToClient Link[S]
+}
+
+// NewNetwork returns a network with perfect links in both directions. The random number
+// generators for each direction are derived from seed.
+func NewNetwork[C any, S any](seed int64) *Network[C, S] {
+	n := &Network[C, S]{}
+	seeds := rand.New(rand.NewSource(seed))
+	n.ClientToServer.Seed(seeds.Int63())
+	n.ServerToClient.Seed(seeds.Int63())
+	return n
+}
+
+// SetLatencyMS sets the one way latency in both directions.
+func (n *Network[C, S]) SetLatencyMS(latencyMS float64) {
+	n.ClientToServer.Config.LatencyMS = latencyMS
+	n.ServerToClient.Config.LatencyMS = latencyMS
+}
+
+// SendToServer sends msg from the client to the server.
+func (n *Network[C, S]) SendToServer(nowMS float64, msg C) {
+	n.ClientToServer.Send(nowMS, msg)
+}
+
+// SendToClient sends msg from the server to the client.
+func (n *Network[C, S]) SendToClient(nowMS float64, msg S) {
+	n.ServerToClient.Send(nowMS, msg)
+}
+
+// ServerIncoming returns the next message that has arrived at the server by nowMS.
+func (n *Network[C, S]) ServerIncoming(nowMS float64) (C, bool) {
+	return n.ClientToServer.Receive(nowMS)
+}
+
+// ClientIncoming returns the next message that has arrived at the client by nowMS.
+func (n *Network[C, S]) ClientIncoming(nowMS float64) (S, bool) {
+	return n.ServerToClient.Receive(nowMS)
+}
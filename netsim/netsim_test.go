@@ -0,0 +1,244 @@
+package netsim
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestLinkLatency(t *testing.T) {
+	l := &Link[int]{Config: LinkConfig{LatencyMS: 100}}
+	l.Send(0, 1)
+	l.Send(10, 2)
+	if l.Len() != 2 {
+		t.Errorf("Len()=%d; expected 2", l.Len())
+	}
+
+	if v, ok := l.Receive(99); ok {
+		t.Errorf("Receive(99)=%d; should not have arrived", v)
+	}
+	if v, ok := l.Receive(100); !ok || v != 1 {
+		t.Errorf("Receive(100)=%d,%t; expected 1,true", v, ok)
+	}
+	if v, ok := l.Receive(100); ok {
+		t.Errorf("Receive(100)=%d; second message should not have arrived", v)
+	}
+	if v, ok := l.Receive(200); !ok || v != 2 {
+		t.Errorf("Receive(200)=%d,%t; expected 2,true", v, ok)
+	}
+	if v, ok := l.Receive(1000); ok {
+		t.Errorf("Receive(1000)=%d; link should be empty", v)
+	}
+}
+
+func TestLinkInOrder(t *testing.T) {
+	l := &Link[int]{}
+	for i := 0; i < 10; i++ {
+		l.Send(float64(i), i)
+	}
+	for i := 0; i < 10; i++ {
+		v, ok := l.Receive(100)
+		if !ok || v != i {
+			t.Fatalf("Receive()=%d,%t; expected %d,true", v, ok, i)
+		}
+	}
+}
+
+func TestNetworkDirections(t *testing.T) {
+	n := &Network[string, int]{}
+	n.SetLatencyMS(50)
+	n.SendToServer(0, "input")
+	n.SendToClient(0, 42)
+
+	if _, ok := n.ClientIncoming(49); ok {
+		t.Error("client message arrived too early")
+	}
+	if msg, ok := n.ServerIncoming(50); !ok || msg != "input" {
+		t.Errorf("ServerIncoming(50)=%#v,%t", msg, ok)
+	}
+	if msg, ok := n.ClientIncoming(50); !ok || msg != 42 {
+		t.Errorf("ClientIncoming(50)=%#v,%t", msg, ok)
+	}
+}
+
+// receiveAll sends count messages, one per millisecond, then receives all of them.
+func receiveAll(l *Link[int], count int) []int {
+	for i := 0; i < count; i++ {
+		l.Send(float64(i), i)
+	}
+	var out []int
+	for {
+		v, ok := l.Receive(math.MaxFloat64)
+		if !ok {
+			return out
+		}
+		out = append(out, v)
+	}
+}
+
+func TestLinkJitterInOrder(t *testing.T) {
+	for _, dist := range []Distribution{JitterUniform, JitterNormal, JitterExponential} {
+		l := &Link[int]{Config: LinkConfig{LatencyMS: 10, JitterMS: 50, Jitter: dist}}
+		l.Seed(1)
+		out := receiveAll(l, 100)
+		if len(out) != 100 {
+			t.Fatalf("%s: received %d messages; expected 100", dist, len(out))
+		}
+		for i, v := range out {
+			if v != i {
+				t.Fatalf("%s: out[%d]=%d; jitter must not reorder messages", dist, i, v)
+			}
+		}
+	}
+}
+
+func TestLinkJitterDelays(t *testing.T) {
+	l := &Link[int]{Config: LinkConfig{LatencyMS: 10, JitterMS: 50}}
+	l.Seed(1)
+	l.Send(0, 1)
+	if _, ok := l.Receive(9.9); ok {
+		t.Error("message arrived before the minimum latency")
+	}
+	if _, ok := l.Receive(60); !ok {
+		t.Error("message did not arrive after the maximum uniform jitter")
+	}
+}
+
+func TestLinkLossDuplicateReorder(t *testing.T) {
+	const count = 1000
+
+	l := &Link[int]{Config: LinkConfig{LossProbability: 1}}
+	if out := receiveAll(l, count); len(out) != 0 {
+		t.Errorf("LossProbability=1 delivered %d messages", len(out))
+	}
+	if l.Stats().Lost != count {
+		t.Errorf("Stats().Lost=%d; expected %d", l.Stats().Lost, count)
+	}
+
+	l = &Link[int]{Config: LinkConfig{DuplicateProbability: 1}}
+	if out := receiveAll(l, count); len(out) != 2*count {
+		t.Errorf("DuplicateProbability=1 delivered %d messages; expected %d", len(out), 2*count)
+	}
+
+	l = &Link[int]{Config: LinkConfig{LossProbability: 0.25, ReorderProbability: 0.1}}
+	l.Seed(1)
+	out := receiveAll(l, count)
+	stats := l.Stats()
+	if !(200 < stats.Lost && stats.Lost < 300) {
+		t.Errorf("Stats().Lost=%d; expected about %d", stats.Lost, count/4)
+	}
+	if stats.Reordered == 0 {
+		t.Error("Stats().Reordered=0; expected some messages to be reordered")
+	}
+	if stats.Delivered != len(out) || len(out) != count-stats.Lost {
+		t.Errorf("Delivered=%d len(out)=%d Lost=%d; inconsistent", stats.Delivered, len(out), stats.Lost)
+	}
+	outOfOrder := 0
+	for i := 1; i < len(out); i++ {
+		if out[i] < out[i-1] {
+			outOfOrder++
+		}
+	}
+	if outOfOrder == 0 {
+		t.Error("ReorderProbability=0.1 delivered all messages in order")
+	}
+}
+
+func TestNetworkSeedReproducible(t *testing.T) {
+	config := LinkConfig{LatencyMS: 10, JitterMS: 30, Jitter: JitterExponential,
+		LossProbability: 0.1, DuplicateProbability: 0.1, ReorderProbability: 0.1}
+	run := func(seed int64) []int {
+		n := NewNetwork[int, int](seed)
+		n.ClientToServer.Config = config
+		return receiveAll(&n.ClientToServer, 200)
+	}
+
+	if !reflect.DeepEqual(run(42), run(42)) {
+		t.Error("the same seed produced different deliveries")
+	}
+	if reflect.DeepEqual(run(42), run(43)) {
+		t.Error("different seeds produced the same deliveries")
+	}
+}
+
+func TestNetworkAsymmetric(t *testing.T) {
+	n := NewNetwork[int, int](1)
+	n.ClientToServer.Config.LatencyMS = 200
+	n.ServerToClient.Config.LatencyMS = 20
+	n.SendToServer(0, 1)
+	n.SendToClient(0, 2)
+
+	if _, ok := n.ClientIncoming(20); !ok {
+		t.Error("downlink message should arrive after 20 ms")
+	}
+	if _, ok := n.ServerIncoming(199); ok {
+		t.Error("uplink message should not arrive before 200 ms")
+	}
+	if _, ok := n.ServerIncoming(200); !ok {
+		t.Error("uplink message should arrive after 200 ms")
+	}
+}
+
+func TestLinkBandwidth(t *testing.T) {
+	// 1000 bytes/second: each 100 byte message takes 100 ms to transmit
+	l := &Link[int]{Config: LinkConfig{LatencyMS: 10, BytesPerSecond: 1000}}
+	l.Size = func(int) int { return 100 }
+	l.Send(0, 1)
+	l.Send(0, 2)
+	l.Send(500, 3)
+
+	expected := []struct {
+		nowMS float64
+		value int
+	}{
+		{110, 1},
+		{210, 2},
+		// the link was idle: no queueing
+		{610, 3},
+	}
+	for _, e := range expected {
+		if v, ok := l.Receive(e.nowMS - 0.1); ok {
+			t.Errorf("Receive(%f)=%d; arrived too early", e.nowMS-0.1, v)
+		}
+		if v, ok := l.Receive(e.nowMS); !ok || v != e.value {
+			t.Errorf("Receive(%f)=%d,%t; expected %d,true", e.nowMS, v, ok, e.value)
+		}
+	}
+
+	stats := l.Stats()
+	if stats.Bytes != 300 || stats.QueueMS != 100 {
+		t.Errorf("Bytes=%d QueueMS=%f; expected 300 and 100", stats.Bytes, stats.QueueMS)
+	}
+}
+
+func TestLinkMTU(t *testing.T) {
+	size := func(v int) int { return v }
+
+	l := &Link[int]{Config: LinkConfig{MTU: 100}, Size: size}
+	out := receiveAll(l, 250)
+	if len(out) != 101 || l.Stats().Oversize != 149 {
+		t.Errorf("delivered %d messages, Oversize=%d; expected 101 and 149",
+			len(out), l.Stats().Oversize)
+	}
+
+	l = &Link[int]{Config: LinkConfig{MTU: 100, Fragment: true}, Size: size}
+	l.Send(0, 250)
+	if _, ok := l.Receive(0); !ok {
+		t.Error("fragmented message was not delivered")
+	}
+	if l.Stats().Fragments != 3 {
+		t.Errorf("Fragments=%d; expected 3", l.Stats().Fragments)
+	}
+
+	// each fragment can be lost: large messages are lost more often
+	l = &Link[int]{Config: LinkConfig{MTU: 100, Fragment: true, LossProbability: 0.1}, Size: size}
+	l.Seed(1)
+	for i := 0; i < 1000; i++ {
+		l.Send(0, 1000)
+	}
+	lossRate := float64(l.Stats().Lost) / 1000
+	// 1 - 0.9^10 = 0.65
+	if !(0.6 < lossRate && lossRate < 0.7) {
+		t.Errorf("loss rate for 10 fragment messages=%f; expected about 0.65", lossRate)
+	}
+}
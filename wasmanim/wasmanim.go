@@ -8,17 +8,37 @@ import (
 	"image"
 	"log"
 	"math"
+	"strings"
 	"syscall/js"
 
 	"github.com/evanj/netgamesim/game"
+	"github.com/evanj/netgamesim/inputscript"
+	"github.com/evanj/netgamesim/netcode"
+	"github.com/evanj/netgamesim/netsim"
 	"github.com/evanj/netgamesim/sprites"
 	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
 )
 
-const clientCanvasID = "clientCanvas"
+// the client canvases are clientCanvas0, clientCanvas1, ...
+const clientCanvasIDPrefix = "clientCanvas"
 const serverCanvasID = "serverCanvas"
 
+// the one way latency of each client, in both directions, so players with low and high latency
+// see each other; the page's sliders change them. The page creates a canvas and controls for each.
+var defaultClientLatencyMS = []float64{0, 50, 200}
+
+const lockstepStatusID = "lockstepStatus"
+
+// number of frames between updates of the lockstep status text
+const lockstepStatusFrames = 30
+
+// default lockstep and rollback input delay in time steps
+const defaultInputDelay = 2
+
+// default maximum rollback window in time steps
+const defaultMaxRollback = 8
+
 const keyCodeSpace = 32
 const keyCodeLeft = 37
 const keyCodeUp = 38
@@ -27,9 +47,13 @@ const keyCodeDown = 40
 
 const logFPSSeconds = 15
 
+// seed for the simulated network's random number generator, so runs are reproducible
+const networkSeed = 1
+
 const tapMS = 100
 const touchMovePixels = 30
 
+// client is the keyboard and touch input, which controls one of the clients.
 type client struct {
 	keyDownCallback    js.Func
 	keyUpCallback      js.Func
@@ -37,8 +61,6 @@ type client struct {
 	touchMoveCallback  js.Func
 	touchEndCallback   js.Func
 
-	game *game.Game
-
 	fireKeyDown bool
 	sendFire    bool
 	tankDir     game.Direction
@@ -48,10 +70,9 @@ type client struct {
 	touchY       float64
 }
 
-func newClient(g *game.Game) *client {
+func newClient() *client {
 	c := &client{
 		js.Func{}, js.Func{}, js.Func{}, js.Func{}, js.Func{},
-		g,
 		false, false, game.DirNone,
 		0.0, 0.0, 0.0,
 	}
@@ -131,8 +152,9 @@ func (c *client) jsKeyUp(this js.Value, args []js.Value) interface{} {
 		return nil
 	}
 	if dir == c.tankDir {
+		// the next frame sends the stop with ApplyInput, so it is predicted and reconciled like
+		// any other input
 		c.tankDir = game.DirNone
-		c.game.ProcessInput(game.Input{TankDir: game.DirNone, Fire: false})
 	}
 	return nil
 }
@@ -213,7 +235,9 @@ func (c *client) jsTouchEnd(this js.Value, args []js.Value) interface{} {
 }
 
 func drawGame(gc draw2d.GraphicContext, g *game.Game) {
-	sprites.DrawTank(gc, g.TankCenter())
+	for player := game.PlayerID(0); int(player) < g.Players(); player++ {
+		sprites.DrawTank(gc, g.TankCenter(player))
+	}
 	sprites.DrawTarget(gc, g.TargetCenter())
 	for _, b := range g.Bullets() {
 		sprites.DrawBullet(gc, b)
@@ -223,111 +247,112 @@ func drawGame(gc draw2d.GraphicContext, g *game.Game) {
 	}
 }
 
-type clientMessage struct {
-	sentTime float64
-	input    game.Input
-}
-
-type serverMessage struct {
-	sentTime float64
-	state    *game.Game
-}
-
-type network struct {
-	currentMS float64
-	latencyMS float64
-
-	clientToServer []clientMessage
-	serverToClient []serverMessage
-}
-
-func (n *network) getServerIncoming(current float64) *game.Input {
-	if len(n.clientToServer) == 0 {
-		return nil
-	}
-
-	// all messages before this time should be delivered
-	deliveredSentTime := current - n.latencyMS
-	if n.clientToServer[0].sentTime <= deliveredSentTime {
-		out := n.clientToServer[0].input
-		n.clientToServer = n.clientToServer[1:]
-		return &out
-	}
-	return nil
-}
-
-func (n *network) getClientIncoming(current float64) *game.Game {
-	if len(n.serverToClient) == 0 {
-		return nil
-	}
-
-	// all messages before this time should be delivered
-	deliveredSentTime := current - n.latencyMS
-	if n.serverToClient[0].sentTime <= deliveredSentTime {
-		out := n.serverToClient[0].state
-		n.serverToClient = n.serverToClient[1:]
-		return out
-	}
-	return nil
-}
-
-func (n *network) sendToClient(current float64, g *game.Game) {
-	n.serverToClient = append(n.serverToClient, serverMessage{current, g})
-}
-
-func (n *network) sendToServer(current float64, i game.Input) {
-	n.clientToServer = append(n.clientToServer, clientMessage{current, i})
-}
-
-type server struct {
-	game *game.Game
-}
-
-func newServer() *server {
-	return &server{game.New()}
-}
-
-func (s *server) executeTimeStep() *game.Game {
-	s.game.SimulateTimeStep()
-	return s.game.Clone()
-}
-
 type simulation struct {
 	simTimeStart float64
-	net          *network
-
-	client       *client
-	clientScreen *canvasScreen
-
-	server       *server
-	serverScreen *canvasScreen
-
-	requestFrame    js.Func
-	latencyAdjusted js.Func
+	loop         *netcode.Loop
+
+	// the input controls the active client; the others send no input
+	client        *client
+	active        game.PlayerID
+	clientScreens []*canvasScreen
+	serverScreen  *canvasScreen
+
+	// the input script that replaces the keyboard and touch input, if not nil
+	script *inputscript.Player
+	// time the script started; negative if it starts at the next frame
+	scriptStartMS float64
+
+	// the deterministic lockstep or rollback model, which replaces the client/server model if
+	// not nil
+	lockstep *netcode.LockstepLoop
+	rollback *netcode.RollbackLoop
+	// time the lockstep or rollback game started; negative if it starts at the next frame
+	peerStartMS    float64
+	inputDelay     int
+	maxRollback    int
+	lockstepStatus js.Value
+
+	requestFrame             js.Func
+	latencyAdjusted          js.Func
+	linkAdjusted             []jsLinkSetting
+	predictionAdjusted       js.Func
+	reconciliationAdjusted   js.Func
+	interpolationAdjusted    js.Func
+	lagCompensationAdjusted  js.Func
+	deltaCompressionAdjusted js.Func
+	scriptAdjusted           js.Func
+	activeClientAdjusted     js.Func
+	lockstepAdjusted         js.Func
+	rollbackAdjusted         js.Func
+	inputDelayAdjusted       js.Func
+	maxRollbackAdjusted      js.Func
 
 	lastFPSLogTime float64
 	frames         int
 }
 
-func newSimulation(clientScreen *canvasScreen, serverScreen *canvasScreen) *simulation {
+func newSimulation(clientScreens []*canvasScreen, serverScreen *canvasScreen) *simulation {
+	loop, err := netcode.NewLoop(game.DefaultConfig(), networkSeed)
+	if err != nil {
+		panic(err)
+	}
+	for i := 1; i < len(clientScreens); i++ {
+		loop.AddClient(networkSeed + int64(i))
+	}
+	for i, net := range loop.Nets {
+		net.SetLatencyMS(defaultClientLatencyMS[i])
+	}
 	sim := &simulation{
-		0.0, &network{},
+		0.0, loop,
+
+		newClient(), 0, clientScreens, serverScreen,
 
-		newClient(game.New()), clientScreen,
+		nil, 0.0,
 
-		newServer(), serverScreen,
+		nil, nil, 0.0, defaultInputDelay, defaultMaxRollback, js.Value{},
 
-		js.Func{}, js.Func{},
+		js.Func{}, js.Func{}, nil, js.Func{}, js.Func{}, js.Func{}, js.Func{}, js.Func{}, js.Func{},
+		js.Func{}, js.Func{}, js.Func{}, js.Func{}, js.Func{},
 
 		0.0, 0,
 	}
 	sim.requestFrame = js.FuncOf(sim.jsRequestFrame)
 	sim.latencyAdjusted = js.FuncOf(sim.jsLatencyAdjusted)
+	sim.predictionAdjusted = js.FuncOf(sim.jsPredictionAdjusted)
+	sim.reconciliationAdjusted = js.FuncOf(sim.jsReconciliationAdjusted)
+	sim.interpolationAdjusted = js.FuncOf(sim.jsInterpolationAdjusted)
+	sim.lagCompensationAdjusted = js.FuncOf(sim.jsLagCompensationAdjusted)
+	sim.deltaCompressionAdjusted = js.FuncOf(sim.jsDeltaCompressionAdjusted)
+	sim.scriptAdjusted = js.FuncOf(sim.jsScriptAdjusted)
+	sim.activeClientAdjusted = js.FuncOf(sim.jsActiveClientAdjusted)
+	sim.lockstepAdjusted = js.FuncOf(sim.jsLockstepAdjusted)
+	sim.rollbackAdjusted = js.FuncOf(sim.jsRollbackAdjusted)
+	sim.inputDelayAdjusted = js.FuncOf(sim.jsInputDelayAdjusted)
+	sim.maxRollbackAdjusted = js.FuncOf(sim.jsMaxRollbackAdjusted)
+	sim.linkAdjusted = newJSLinkSettings(
+		&loop.Net.ClientToServer.Config, &loop.Net.ServerToClient.Config)
+	for i := 1; i < len(loop.Nets); i++ {
+		sim.linkAdjusted = append(sim.linkAdjusted, newJSClientSettings(i, loop.Nets[i])...)
+	}
 	return sim
 }
 
 func (s *simulation) Stop() {
 	s.latencyAdjusted.Release()
+	s.predictionAdjusted.Release()
+	s.reconciliationAdjusted.Release()
+	s.interpolationAdjusted.Release()
+	s.lagCompensationAdjusted.Release()
+	s.deltaCompressionAdjusted.Release()
+	s.scriptAdjusted.Release()
+	s.activeClientAdjusted.Release()
+	s.lockstepAdjusted.Release()
+	s.rollbackAdjusted.Release()
+	s.inputDelayAdjusted.Release()
+	s.maxRollbackAdjusted.Release()
+	for _, setting := range s.linkAdjusted {
+		setting.fn.Release()
+	}
 	s.client.Stop()
 }
 
@@ -340,45 +365,74 @@ func (s *simulation) jsRequestFrame(this js.Value, args []js.Value) interface{}
 
 	msSinceStart := msSinceDocStart - s.simTimeStart
 
-	// simulate the network advancing by single ticks; we can't show anything more often than 60
-	// fps anyway, so latency is "quantized" to frames anaway
-	for serverTime := s.net.currentMS + game.TimeStepMS; serverTime < msSinceStart; serverTime += game.TimeStepMS {
-		// process server network input
-		for {
-			input := s.net.getServerIncoming(serverTime)
-			if input == nil {
-				break
-			}
-			s.server.game.ProcessInput(*input)
-		}
-
-		// simulate the time on the server; send the updated state to the client
-		state := s.server.executeTimeStep()
-		s.net.sendToClient(serverTime, state)
+	s.loop.AdvanceTo(msSinceStart)
 
-		// process client network messages by replacing the game state
-		for {
-			state := s.net.getClientIncoming(serverTime)
-			if state == nil {
-				break
-			}
-			s.client.game = state
-		}
-
-		s.net.currentMS = serverTime
-	}
-	// client sends a message to the server every frame
+	// the active client sends the input to the server every frame
 	input := game.Input{
 		TankDir: s.client.tankDir,
 		Fire:    s.client.sendFire,
 	}
 	s.client.sendFire = false
-	s.net.sendToServer(msSinceStart, input)
+	if s.script != nil {
+		if s.scriptStartMS < 0 {
+			s.scriptStartMS = msSinceStart
+		}
+		input = s.script.Input(int((msSinceStart - s.scriptStartMS) / game.DefaultTimeStepMS))
+	}
+
+	if (s.lockstep != nil || s.rollback != nil) && s.peerStartMS < 0 {
+		s.peerStartMS = msSinceStart
+	}
+	peerMS := msSinceStart - s.peerStartMS
 
 	// draw the state of the universe
-	drawGame(s.clientScreen.gc, s.client.game)
-	s.clientScreen.renderFrame()
-	drawGame(s.serverScreen.gc, s.server.game)
+	if s.lockstep != nil {
+		// the link settings are stored in the client/server network
+		s.lockstep.Net.ClientToServer.Config = s.loop.Net.ClientToServer.Config
+		s.lockstep.Net.ServerToClient.Config = s.loop.Net.ServerToClient.Config
+		s.lockstep.AdvanceTo(peerMS)
+		s.lockstep.SendInput(peerMS, input)
+
+		drawGame(s.clientScreens[0].gc, s.lockstep.Render(peerMS))
+		drawGame(s.serverScreen.gc, s.lockstep.Server.Game())
+		if s.frames%lockstepStatusFrames == 0 {
+			stats := s.lockstep.Client.Stats()
+			s.lockstepStatus.Set("textContent", fmt.Sprintf(
+				"simulated %d time steps; stalled %d (longest %.0f ms); mean input delay %.0f ms",
+				stats.Ticks, stats.StallTicks, stats.MaxStallMS,
+				stats.TotalInputDelayMS/math.Max(1, float64(stats.InputDelays))))
+		}
+	} else if s.rollback != nil {
+		s.rollback.Net.ClientToServer.Config = s.loop.Net.ClientToServer.Config
+		s.rollback.Net.ServerToClient.Config = s.loop.Net.ServerToClient.Config
+		s.rollback.AdvanceTo(peerMS)
+		s.rollback.SendInput(peerMS, input)
+
+		drawGame(s.clientScreens[0].gc, s.rollback.Render(peerMS))
+		drawGame(s.serverScreen.gc, s.rollback.Server.Game())
+		if s.frames%lockstepStatusFrames == 0 {
+			// only the server mispredicts: the client controls the tank
+			stats := s.rollback.Server.Stats()
+			s.lockstepStatus.Set("textContent", fmt.Sprintf(
+				"simulated %d time steps; server rolled back %d times (%d time steps, at most %d); stalled %d (longest %.0f ms)",
+				stats.Ticks, stats.Rollbacks, stats.RollbackTicks, stats.MaxRollbackTicks,
+				stats.StallTicks, stats.MaxStallMS))
+		}
+	} else {
+		for player, client := range s.loop.Clients {
+			clientInput := game.Input{}
+			if game.PlayerID(player) == s.active {
+				clientInput = input
+			}
+			s.loop.SendPlayerInput(msSinceStart, game.PlayerID(player), clientInput)
+			drawGame(s.clientScreens[player].gc, client.Render(msSinceStart))
+		}
+		drawGame(s.serverScreen.gc, s.loop.Server.Game())
+	}
+	// the other client canvases are blank in the lockstep and rollback modes
+	for _, screen := range s.clientScreens {
+		screen.renderFrame()
+	}
 	s.serverScreen.renderFrame()
 
 	// request the next frame
@@ -389,6 +443,28 @@ func (s *simulation) jsRequestFrame(this js.Value, args []js.Value) interface{}
 		seconds := (msSinceDocStart - s.lastFPSLogTime) / 1000.0
 		fps := float64(s.frames) / seconds
 		log.Printf("t=%f frames=%d seconds=%f fps=%f", msSinceDocStart, s.frames, seconds, fps)
+		for player, client := range s.loop.Clients {
+			net := s.loop.Nets[player]
+			log.Printf("client %d uplink stats %+v", player, net.ClientToServer.Stats())
+			log.Printf("client %d downlink stats %+v", player, net.ServerToClient.Stats())
+			log.Printf("client %d prediction correction stats %+v", player, client.CorrectionStats())
+			log.Printf("client %d prediction desync stats %+v", player, client.DesyncStats())
+			log.Printf("client %d interpolation stats %+v", player, client.Interpolation.Stats())
+		}
+		log.Printf("snapshot stats %+v", s.loop.Server.SnapshotStats())
+		log.Printf("hit stats %+v", s.loop.Server.HitStats())
+		if s.lockstep != nil {
+			log.Printf("lockstep client stats %+v", s.lockstep.Client.Stats())
+			log.Printf("lockstep server stats %+v", s.lockstep.Server.Stats())
+			log.Printf("lockstep uplink stats %+v", s.lockstep.Net.ClientToServer.Stats())
+			log.Printf("lockstep downlink stats %+v", s.lockstep.Net.ServerToClient.Stats())
+		}
+		if s.rollback != nil {
+			log.Printf("rollback client stats %+v", s.rollback.Client.Stats())
+			log.Printf("rollback server stats %+v", s.rollback.Server.Stats())
+			log.Printf("rollback uplink stats %+v", s.rollback.Net.ClientToServer.Stats())
+			log.Printf("rollback downlink stats %+v", s.rollback.Net.ServerToClient.Stats())
+		}
 		s.frames = 0
 		s.lastFPSLogTime = msSinceDocStart
 	}
@@ -398,36 +474,265 @@ func (s *simulation) jsRequestFrame(this js.Value, args []js.Value) interface{}
 func (s *simulation) jsLatencyAdjusted(this js.Value, args []js.Value) interface{} {
 	v := args[0].Float()
 	log.Printf("latency adjusted = %f", v)
-	s.net.latencyMS = v
+	s.loop.Net.SetLatencyMS(v)
+	return nil
+}
+
+func (s *simulation) jsPredictionAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Bool()
+	log.Printf("client prediction = %t", v)
+	for _, client := range s.loop.Clients {
+		client.Predict = v
+	}
+	return nil
+}
+
+func (s *simulation) jsReconciliationAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Bool()
+	log.Printf("server reconciliation = %t", v)
+	for _, client := range s.loop.Clients {
+		client.Reconcile = v
+	}
+	return nil
+}
+
+func (s *simulation) jsInterpolationAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Float()
+	log.Printf("interpolation delay = %f", v)
+	for _, client := range s.loop.Clients {
+		client.Interpolation.DelayMS = v
+	}
+	return nil
+}
+
+func (s *simulation) jsLagCompensationAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Bool()
+	log.Printf("lag compensation = %t", v)
+	s.loop.Server.LagCompensation = v
+	for _, client := range s.loop.Clients {
+		client.LagCompensation = v
+	}
+	return nil
+}
+
+func (s *simulation) jsDeltaCompressionAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Bool()
+	log.Printf("delta compression = %t", v)
+	s.loop.Server.DeltaCompression = v
+	return nil
+}
+
+// jsActiveClientAdjusted gives the keyboard, touch and script input to client args[0].
+func (s *simulation) jsActiveClientAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Int()
+	if !(0 <= v && v < len(s.loop.Clients)) {
+		log.Printf("invalid client %d", v)
+		return nil
+	}
+	log.Printf("active client = %d", v)
+	s.active = game.PlayerID(v)
+	return nil
+}
+
+// jsLockstepAdjusted switches between a new deterministic lockstep game and the client/server
+// game.
+func (s *simulation) jsLockstepAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Bool()
+	log.Printf("lockstep = %t", v)
+	s.startPeers(v, false)
+	return nil
+}
+
+// jsRollbackAdjusted switches between a new rollback game and the client/server game.
+func (s *simulation) jsRollbackAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Bool()
+	log.Printf("rollback = %t", v)
+	s.startPeers(false, v)
+	return nil
+}
+
+func (s *simulation) jsInputDelayAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Int()
+	if v < 0 {
+		log.Printf("invalid input delay %d: must be at least 0", v)
+		return nil
+	}
+	log.Printf("lockstep and rollback input delay = %d", v)
+	s.inputDelay = v
+	// the input delay cannot change during a game
+	s.startPeers(s.lockstep != nil, s.rollback != nil)
 	return nil
 }
 
+func (s *simulation) jsMaxRollbackAdjusted(this js.Value, args []js.Value) interface{} {
+	v := args[0].Int()
+	if !(0 <= v && v <= netcode.MaxRollbackTicks) {
+		log.Printf("invalid max rollback %d: must be between 0 and %d", v, netcode.MaxRollbackTicks)
+		return nil
+	}
+	log.Printf("max rollback = %d", v)
+	s.maxRollback = v
+	s.startPeers(s.lockstep != nil, s.rollback != nil)
+	return nil
+}
+
+// startPeers starts a new lockstep or rollback game at the next frame, or returns to the
+// client/server game if both are false.
+func (s *simulation) startPeers(lockstep bool, rollback bool) {
+	s.lockstep = nil
+	s.rollback = nil
+	var err error
+	if lockstep {
+		s.lockstep, err = netcode.NewLockstepLoop(game.DefaultConfig(), networkSeed, s.inputDelay)
+	} else if rollback {
+		s.rollback, err = netcode.NewRollbackLoop(game.DefaultConfig(), networkSeed, s.inputDelay,
+			s.maxRollback)
+	}
+	if err != nil {
+		log.Printf("failed to start the game: %s", err.Error())
+	}
+	if s.lockstep == nil && s.rollback == nil {
+		s.lockstepStatus.Set("textContent", "")
+	}
+	s.peerStartMS = -1
+}
+
+// jsScriptAdjusted plays the input script in args[0], or returns to keyboard and touch input if
+// it is empty. It returns a status message for the page.
+func (s *simulation) jsScriptAdjusted(this js.Value, args []js.Value) interface{} {
+	text := args[0].String()
+	if strings.TrimSpace(text) == "" {
+		log.Printf("input script stopped")
+		s.script = nil
+		return "keyboard input"
+	}
+
+	script, err := inputscript.Parse(text)
+	if err != nil {
+		log.Printf("invalid input script: %s", err.Error())
+		return err.Error()
+	}
+	log.Printf("playing input script")
+	s.script = script.Player()
+	s.scriptStartMS = -1
+	return "playing script"
+}
+
+// jsLinkSetting is a JavaScript function that adjusts one setting of a network link.
+type jsLinkSetting struct {
+	name string
+	fn   js.Func
+}
+
+// newJSLinkSettings returns the functions to adjust the uplink (client to server) and
+// downlink (server to client) settings independently.
+func newJSLinkSettings(uplink *netsim.LinkConfig, downlink *netsim.LinkConfig) []jsLinkSetting {
+	var settings []jsLinkSetting
+	add := func(name string, set func(v float64)) {
+		fn := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			v := args[0].Float()
+			log.Printf("%s = %f", name, v)
+			set(v)
+			return nil
+		})
+		settings = append(settings, jsLinkSetting{name, fn})
+	}
+
+	for _, link := range []struct {
+		prefix string
+		config *netsim.LinkConfig
+	}{{"gameUplink", uplink}, {"gameDownlink", downlink}} {
+		config := link.config
+		add(link.prefix+"LatencyAdjusted", func(v float64) { config.LatencyMS = v })
+		add(link.prefix+"JitterAdjusted", func(v float64) { config.JitterMS = v })
+		// the slider is a percentage
+		add(link.prefix+"LossAdjusted", func(v float64) { config.LossProbability = v / 100 })
+		// the slider is in KiB/second
+		add(link.prefix+"BandwidthAdjusted", func(v float64) { config.BytesPerSecond = v * 1024 })
+		add(link.prefix+"MTUAdjusted", func(v float64) { config.MTU = int(v) })
+		add(link.prefix+"FragmentAdjusted", func(v float64) { config.Fragment = v != 0 })
+	}
+	return settings
+}
+
+// newJSClientSettings returns the functions to adjust the latency and jitter of client player's
+// network in both directions, named gameClient<player>LatencyAdjusted and
+// gameClient<player>JitterAdjusted.
+func newJSClientSettings(player int, net *netcode.Network) []jsLinkSetting {
+	var settings []jsLinkSetting
+	add := func(name string, set func(v float64)) {
+		name = fmt.Sprintf("gameClient%d%s", player, name)
+		fn := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			v := args[0].Float()
+			log.Printf("%s = %f", name, v)
+			set(v)
+			return nil
+		})
+		settings = append(settings, jsLinkSetting{name, fn})
+	}
+	add("LatencyAdjusted", net.SetLatencyMS)
+	add("JitterAdjusted", func(v float64) {
+		net.ClientToServer.Config.JitterMS = v
+		net.ServerToClient.Config.JitterMS = v
+	})
+	return settings
+}
+
 func main() {
-	log.Printf("demo loading in client canvas=%s; server canvas=%s ...",
-		clientCanvasID, serverCanvasID)
+	log.Printf("demo loading in client canvases=%s0..%d; server canvas=%s ...",
+		clientCanvasIDPrefix, len(defaultClientLatencyMS)-1, serverCanvasID)
 
-	// locate the client canvas
+	// create and locate the client canvases
+	latencies := make([]interface{}, len(defaultClientLatencyMS))
+	for i, latencyMS := range defaultClientLatencyMS {
+		latencies[i] = latencyMS
+	}
+	js.Global().Call("addClients", latencies)
 	document := js.Global().Get("document")
-	clientCanvasElement := document.Call("getElementById", clientCanvasID)
-	clientScreen := newScreen(clientCanvasElement)
+	var clientCanvasElements []js.Value
+	var clientScreens []*canvasScreen
+	for i := range defaultClientLatencyMS {
+		element := document.Call("getElementById", fmt.Sprintf("%s%d", clientCanvasIDPrefix, i))
+		clientCanvasElements = append(clientCanvasElements, element)
+		clientScreens = append(clientScreens, newScreen(element))
+	}
 	log.Printf("client canvas dimensions device ratio:%f width:%d x height:%d",
-		clientScreen.devicePixelRatio, clientScreen.devicePixelWidth, clientScreen.devicePixelHeight)
+		clientScreens[0].devicePixelRatio, clientScreens[0].devicePixelWidth,
+		clientScreens[0].devicePixelHeight)
 
 	serverCanvasElement := document.Call("getElementById", serverCanvasID)
 	serverScreen := newScreen(serverCanvasElement)
 
-	s := newSimulation(clientScreen, serverScreen)
+	s := newSimulation(clientScreens, serverScreen)
+	s.lockstepStatus = document.Call("getElementById", lockstepStatusID)
 	defer s.Stop()
 
 	document.Call("addEventListener", "keydown", s.client.keyDownCallback)
 	document.Call("addEventListener", "keyup", s.client.keyUpCallback)
-	clientCanvasElement.Call("addEventListener", "touchstart", s.client.touchStartCallback)
-	clientCanvasElement.Call("addEventListener", "touchend", s.client.touchEndCallback)
-	clientCanvasElement.Call("addEventListener", "touchcancel", s.client.touchEndCallback)
-	clientCanvasElement.Call("addEventListener", "touchmove", s.client.touchMoveCallback)
+	// touching any client canvas controls the active client
+	for _, element := range clientCanvasElements {
+		element.Call("addEventListener", "touchstart", s.client.touchStartCallback)
+		element.Call("addEventListener", "touchend", s.client.touchEndCallback)
+		element.Call("addEventListener", "touchcancel", s.client.touchEndCallback)
+		element.Call("addEventListener", "touchmove", s.client.touchMoveCallback)
+	}
 
 	js.Global().Call("requestAnimationFrame", s.requestFrame)
 	js.Global().Set("gameLatencyAdjusted", s.latencyAdjusted)
+	js.Global().Set("gamePredictionAdjusted", s.predictionAdjusted)
+	js.Global().Set("gameReconciliationAdjusted", s.reconciliationAdjusted)
+	js.Global().Set("gameInterpolationAdjusted", s.interpolationAdjusted)
+	js.Global().Set("gameLagCompensationAdjusted", s.lagCompensationAdjusted)
+	js.Global().Set("gameDeltaCompressionAdjusted", s.deltaCompressionAdjusted)
+	js.Global().Set("gameScriptAdjusted", s.scriptAdjusted)
+	js.Global().Set("gameActiveClientAdjusted", s.activeClientAdjusted)
+	js.Global().Set("gameLockstepAdjusted", s.lockstepAdjusted)
+	js.Global().Set("gameRollbackAdjusted", s.rollbackAdjusted)
+	js.Global().Set("gameInputDelayAdjusted", s.inputDelayAdjusted)
+	js.Global().Set("gameMaxRollbackAdjusted", s.maxRollbackAdjusted)
+	for _, setting := range s.linkAdjusted {
+		js.Global().Set(setting.name, setting.fn)
+	}
 
 	done := make(chan struct{})
 	<-done